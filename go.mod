@@ -11,6 +11,7 @@ require (
 	github.com/onsi/ginkgo/v2 v2.28.1
 	github.com/onsi/gomega v1.39.0
 	github.com/sclevine/spec v1.4.0
+	github.com/tidwall/gjson v1.18.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -44,7 +45,6 @@ require (
 	github.com/paketo-buildpacks/packit/v2 v2.16.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/teris-io/shortid v0.0.0-20220617161101-71ec9f2aa569 // indirect
-	github.com/tidwall/gjson v1.18.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/ulikunitz/xz v0.5.12 // indirect
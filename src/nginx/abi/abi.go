@@ -0,0 +1,199 @@
+// Package abi reads what nginx checks a dynamic module against before it
+// loads it: the nginx version that the module was built for and the
+// signature of the configure flags it was built with.
+package abi
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Module is a dynamic module as nginx sees it when loading it.
+type Module struct {
+	// Names are the modules in the file, such as ngx_http_brotli_filter_module.
+	Names []string
+	// Version is the nginx_version that the module was built for, such as
+	// 1029008 for 1.29.8.
+	Version int
+	// Signature is NGX_MODULE_SIGNATURE of the build, which nginx compares
+	// to its own.
+	Signature string
+}
+
+// signaturePattern matches NGX_MODULE_SIGNATURE: the sizes of pointers,
+// sig_atomic_t and time_t followed by one digit for each feature that
+// changes the layout of nginx's structures.
+var signaturePattern = regexp.MustCompile(`\x00([0-9]{1,2},[0-9]{1,2},[0-9]{1,2},[01]{20,64})\x00`)
+
+// ReadModule reads the modules in the shared object at path.
+func ReadModule(path string) (Module, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return Module{}, fmt.Errorf("%s is not a shared object: %w", path, err)
+	}
+	defer f.Close()
+
+	symbols, err := f.DynamicSymbols()
+	if err != nil {
+		return Module{}, fmt.Errorf("%s has no symbols: %w", path, err)
+	}
+
+	module := Module{}
+	for _, symbol := range symbols {
+		version, ok := moduleVersion(f, symbol)
+		if !ok {
+			continue
+		}
+		if module.Version != 0 && module.Version != version {
+			return Module{}, fmt.Errorf("%s has modules built for nginx %s and %s", path, VersionString(module.Version), VersionString(version))
+		}
+		module.Names = append(module.Names, symbol.Name)
+		module.Version = version
+	}
+	if len(module.Names) == 0 {
+		return Module{}, fmt.Errorf("%s is not an nginx module", path)
+	}
+
+	module.Signature, err = ReadSignature(path)
+	if err != nil {
+		return Module{}, err
+	}
+	return module, nil
+}
+
+// moduleVersion returns the version of the ngx_module_t that symbol names.
+// Its first fields are ctx_index and index, which are unset until nginx
+// loads it, then name, two spare fields and version.
+func moduleVersion(f *elf.File, symbol elf.Symbol) (int, bool) {
+	if elf.ST_TYPE(symbol.Info) != elf.STT_OBJECT || !strings.HasSuffix(symbol.Name, "_module") {
+		return 0, false
+	}
+
+	ptrSize := uint64(4)
+	if f.Class == elf.ELFCLASS64 {
+		ptrSize = 8
+	}
+	if symbol.Size < 7*ptrSize {
+		return 0, false
+	}
+
+	for _, section := range f.Sections {
+		if section.Type == elf.SHT_NOBITS || symbol.Value < section.Addr || symbol.Value+6*ptrSize > section.Addr+section.Size {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			return 0, false
+		}
+		fields := data[symbol.Value-section.Addr:]
+
+		field := func(i uint64) uint64 {
+			if ptrSize == 8 {
+				return f.ByteOrder.Uint64(fields[i*8:])
+			}
+			return uint64(f.ByteOrder.Uint32(fields[i*4:]))
+		}
+		unset := ^uint64(0) >> (64 - ptrSize*8)
+		if field(0) != unset || field(1) != unset {
+			return 0, false
+		}
+		return int(field(5)), true
+	}
+	return 0, false
+}
+
+// ReadSignature returns the NGX_MODULE_SIGNATURE in the nginx binary or
+// module at path.
+func ReadSignature(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	signature := ""
+	for _, match := range signaturePattern.FindAllSubmatch(contents, -1) {
+		if signature != "" && signature != string(match[1]) {
+			return "", fmt.Errorf("%s has more than one module signature", path)
+		}
+		signature = string(match[1])
+	}
+	if signature == "" {
+		return "", fmt.Errorf("%s has no module signature", path)
+	}
+	return signature, nil
+}
+
+// Version returns nginx_version for a version such as 1.29.8. Versions with
+// more parts, such as OpenResty's 1.25.3.2, are cut to the nginx they bundle.
+func Version(version string) (int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 3 {
+		return 0, fmt.Errorf("invalid nginx version %q", version)
+	}
+
+	n := 0
+	for _, part := range parts[:3] {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 || v > 999 {
+			return 0, fmt.Errorf("invalid nginx version %q", version)
+		}
+		n = n*1000 + v
+	}
+	return n, nil
+}
+
+// VersionString returns the version that nginx_version n stands for.
+func VersionString(n int) string {
+	return fmt.Sprintf("%d.%d.%d", n/1000000, n/1000%1000, n%1000)
+}
+
+// ErrIncompatible is wrapped by the errors of Check.
+var ErrIncompatible = errors.New("module is not binary compatible")
+
+// Check compares module to the nginx with nginxVersion and nginxSignature,
+// as nginx does when loading it.
+func Check(module Module, nginxVersion int, nginxSignature string) error {
+	if module.Version != nginxVersion {
+		return fmt.Errorf("%w: built for nginx %s, not %s", ErrIncompatible, VersionString(module.Version), VersionString(nginxVersion))
+	}
+	if module.Signature != nginxSignature {
+		return fmt.Errorf("%w: built with other configure flags than nginx %s, its signature is %s instead of %s%s",
+			ErrIncompatible, VersionString(nginxVersion), module.Signature, nginxSignature, signatureDiff(module.Signature, nginxSignature))
+	}
+	return nil
+}
+
+// signatureDiff names the features that differ between two signatures by
+// position, since their meaning depends on the nginx version.
+func signatureDiff(a, b string) string {
+	aSizes, aFeatures := splitSignature(a)
+	bSizes, bFeatures := splitSignature(b)
+	if aSizes != bSizes {
+		return fmt.Sprintf(" (type sizes %s instead of %s, which is another architecture)", aSizes, bSizes)
+	}
+	if len(aFeatures) != len(bFeatures) {
+		return ""
+	}
+
+	positions := &bytes.Buffer{}
+	for i := range aFeatures {
+		if aFeatures[i] != bFeatures[i] {
+			if positions.Len() > 0 {
+				positions.WriteString(", ")
+			}
+			fmt.Fprintf(positions, "%d", i+1)
+		}
+	}
+	return fmt.Sprintf(" (features %s differ)", positions.String())
+}
+
+func splitSignature(signature string) (string, string) {
+	i := strings.LastIndex(signature, ",")
+	return signature[:i], signature[i+1:]
+}
@@ -0,0 +1,13 @@
+package abi_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestABI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ABI Suite")
+}
@@ -0,0 +1,83 @@
+package abi_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/abi"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ABI", func() {
+	const (
+		modulePath      = "testdata/ngx_http_test_module.so"
+		moduleSignature = "8,4,8,0000111111010111001110111111000110"
+	)
+
+	Describe("ReadModule", func() {
+		It("reads the version and signature that the module was built with", func() {
+			module, err := abi.ReadModule(modulePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(module).To(Equal(abi.Module{
+				Names:     []string{"ngx_http_test_module"},
+				Version:   1025003,
+				Signature: moduleSignature,
+			}))
+		})
+
+		It("fails on files that are not modules", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "ngx_fake_module.so")
+			Expect(os.WriteFile(path, []byte("not elf"), 0644)).To(Succeed())
+			_, err := abi.ReadModule(path)
+			Expect(err).To(MatchError(ContainSubstring("is not a shared object")))
+		})
+	})
+
+	Describe("ReadSignature", func() {
+		It("finds the signature in an nginx binary", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "nginx")
+			Expect(os.WriteFile(path, []byte("\x7fELF\x00nginx/1.29.8\x00"+moduleSignature+"\x00more"), 0755)).To(Succeed())
+			Expect(abi.ReadSignature(path)).To(Equal(moduleSignature))
+		})
+
+		It("fails without a signature", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "nginx")
+			Expect(os.WriteFile(path, []byte("\x7fELF\x00nginx/1.29.8\x00"), 0755)).To(Succeed())
+			_, err := abi.ReadSignature(path)
+			Expect(err).To(MatchError(path + " has no module signature"))
+		})
+	})
+
+	Describe("Version", func() {
+		It("converts nginx and OpenResty versions to nginx_version", func() {
+			Expect(abi.Version("1.29.8")).To(Equal(1029008))
+			Expect(abi.Version("1.25.3.2")).To(Equal(1025003))
+			_, err := abi.Version("1.29")
+			Expect(err).To(MatchError(`invalid nginx version "1.29"`))
+			Expect(abi.VersionString(1029008)).To(Equal("1.29.8"))
+		})
+	})
+
+	Describe("Check", func() {
+		module := abi.Module{Version: 1025003, Signature: moduleSignature}
+
+		It("accepts a module built for the nginx", func() {
+			Expect(abi.Check(module, 1025003, moduleSignature)).To(Succeed())
+		})
+
+		It("rejects a module built for another nginx version", func() {
+			err := abi.Check(module, 1029008, moduleSignature)
+			Expect(errors.Is(err, abi.ErrIncompatible)).To(BeTrue())
+			Expect(err).To(MatchError("module is not binary compatible: built for nginx 1.25.3, not 1.29.8"))
+		})
+
+		It("rejects a module built with other configure flags", func() {
+			err := abi.Check(module, 1025003, "8,4,8,0000111111010111001111111111000111")
+			Expect(err).To(MatchError("module is not binary compatible: built with other configure flags than nginx 1.25.3, its signature is " +
+				moduleSignature + " instead of 8,4,8,0000111111010111001111111111000111 (features 22, 34 differ)"))
+		})
+	})
+})
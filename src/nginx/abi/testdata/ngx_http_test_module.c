@@ -0,0 +1,33 @@
+/*
+ * The parts of a dynamic nginx module that nginx checks before loading it,
+ * laid out like ngx_module_t. Build with:
+ *
+ *   gcc -shared -fPIC -O2 -s -o ngx_http_test_module.so ngx_http_test_module.c
+ */
+
+typedef unsigned long ngx_uint_t;
+
+typedef struct {
+    ngx_uint_t  ctx_index;
+    ngx_uint_t  index;
+    char       *name;
+    ngx_uint_t  spare0;
+    ngx_uint_t  spare1;
+    ngx_uint_t  version;
+    const char *signature;
+    void       *ctx;
+    void       *commands;
+    ngx_uint_t  type;
+    void       *hooks[15];
+} ngx_module_t;
+
+ngx_module_t ngx_http_test_module = {
+    (ngx_uint_t) -1, (ngx_uint_t) -1, 0, 0, 0,
+    1025003,
+    "8,4,8,0000111111010111001110111111000110",
+    0, 0, 0x50545448, { 0 }
+};
+
+ngx_module_t *ngx_modules[] = { &ngx_http_test_module, 0 };
+char *ngx_module_names[] = { "ngx_http_test_module", 0 };
+char *ngx_module_order[] = { 0 };
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/detect"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func main() {
+	logger := libbuildpack.NewLogger(os.Stderr)
+
+	if len(os.Args) < 2 {
+		logger.Error("Usage: detect <build-dir>")
+		os.Exit(1)
+	}
+
+	buildpackDir, err := libbuildpack.GetBuildpackDir()
+	if err != nil {
+		logger.Error("Unable to determine buildpack directory: %s", err.Error())
+		os.Exit(1)
+	}
+
+	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load buildpack manifest: %s", err.Error())
+		os.Exit(1)
+	}
+
+	version, err := manifest.Version()
+	if err != nil {
+		logger.Error("Unable to determine buildpack version: %s", err.Error())
+		os.Exit(1)
+	}
+
+	output, detected, err := detect.Run(&detect.Detector{
+		BuildDir: os.Args[1],
+		Manifest: manifest,
+		Version:  version,
+	})
+	if err != nil {
+		logger.Error("Unable to detect nginx: %s", err.Error())
+		os.Exit(1)
+	}
+	if !detected {
+		fmt.Println("no")
+		os.Exit(1)
+	}
+
+	fmt.Println(output)
+}
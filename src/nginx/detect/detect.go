@@ -0,0 +1,44 @@
+package detect
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
+)
+
+type Detector struct {
+	BuildDir string
+	Manifest supply.Manifest
+	// Version is the version of the buildpack.
+	Version string
+}
+
+// Run reports whether the app in BuildDir has an nginx config at
+// nginx.config_path in buildpack.yml, nginx.conf by default, or an nginx.site
+// section or a Staticfile to generate one from. If it does, Run also returns
+// the line that detect prints, naming the dist and version that supply will
+// install.
+func Run(d *Detector) (string, bool, error) {
+	supplier := &supply.Supplier{Manifest: d.Manifest}
+	if err := supplier.LoadConfig(d.BuildDir); err != nil {
+		return "", false, err
+	}
+
+	confPath := supplier.Config.Nginx.ConfigPath
+	if confPath == "" {
+		confPath = "nginx.conf"
+	}
+	if exists, err := libbuildpack.FileExists(filepath.Join(d.BuildDir, confPath)); err != nil {
+		return "", false, err
+	} else if !exists && supplier.Config.Nginx.Site == nil {
+		return "", false, nil
+	}
+
+	dep, err := supplier.SelectedDependency()
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("nginx %s (%s %s)", d.Version, dep.Name, dep.Version), true, nil
+}
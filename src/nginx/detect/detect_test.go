@@ -0,0 +1,112 @@
+package detect_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/detect"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type manifest struct {
+	rootDir  string
+	versions map[string][]string
+}
+
+func (m manifest) DefaultVersion(depName string) (libbuildpack.Dependency, error) {
+	return libbuildpack.Dependency{}, nil
+}
+
+func (m manifest) AllDependencyVersions(depName string) []string {
+	return m.versions[depName]
+}
+
+func (m manifest) RootDir() string {
+	return m.rootDir
+}
+
+var _ = Describe("Detect", func() {
+	var (
+		buildDir string
+		detector *detect.Detector
+	)
+
+	BeforeEach(func() {
+		buildDir = GinkgoT().TempDir()
+		rootDir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(rootDir, "manifest.yml"), []byte("version_lines:\n  mainline: 1.31.x\n  stable: 1.30.x\n"), 0644)).To(Succeed())
+
+		detector = &detect.Detector{
+			BuildDir: buildDir,
+			Manifest: manifest{rootDir: rootDir, versions: map[string][]string{
+				"nginx":     {"1.30.1", "1.31.0", "1.31.2"},
+				"openresty": {"1.25.3.1", "1.27.1.2"},
+			}},
+			Version: "1.2.3",
+		}
+	})
+
+	It("does not detect an app without nginx.conf", func() {
+		_, detected, err := detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(detected).To(BeFalse())
+	})
+
+	It("reports the nginx version that mainline resolves to", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), nil, 0644)).To(Succeed())
+		output, detected, err := detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(detected).To(BeTrue())
+		Expect(output).To(Equal("nginx 1.2.3 (nginx 1.31.2)"))
+	})
+
+	It("reports the requested dist and version", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), nil, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  version: stable\n"), 0644)).To(Succeed())
+		output, _, err := detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("nginx 1.2.3 (nginx 1.30.1)"))
+
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dist: openresty\n"), 0644)).To(Succeed())
+		output, _, err = detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("nginx 1.2.3 (openresty 1.27.1.2)"))
+	})
+
+	It("looks for the config at nginx.config_path", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  config_path: deploy/nginx/nginx.conf\n"), 0644)).To(Succeed())
+		_, detected, err := detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(detected).To(BeFalse())
+
+		Expect(os.MkdirAll(filepath.Join(buildDir, "deploy", "nginx"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildDir, "deploy", "nginx", "nginx.conf"), nil, 0644)).To(Succeed())
+		_, detected, err = detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(detected).To(BeTrue())
+	})
+
+	It("detects an app that only has nginx.site", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  site:\n    spa: true\n"), 0644)).To(Succeed())
+		_, detected, err := detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(detected).To(BeTrue())
+	})
+
+	It("detects an app that only has a Staticfile", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile"), []byte("pushstate: enabled\n"), 0644)).To(Succeed())
+		_, detected, err := detect.Run(detector)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(detected).To(BeTrue())
+	})
+
+	It("fails when the requested version is not available", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), nil, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  version: 1.1.x\n"), 0644)).To(Succeed())
+		_, _, err := detect.Run(detector)
+		Expect(err).To(HaveOccurred())
+	})
+})
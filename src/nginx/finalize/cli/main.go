@@ -6,6 +6,7 @@ import (
 
 	"github.com/cloudfoundry/nginx-buildpack/src/nginx/finalize"
 	_ "github.com/cloudfoundry/nginx-buildpack/src/nginx/hooks"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -41,6 +42,8 @@ func main() {
 		BuildDir: stager.BuildDir(),
 		DepDir:   stager.DepDir(),
 		Log:      logger,
+		Stager:   stager,
+		Supplier: supply.New(stager, manifest, nil, logger, &libbuildpack.Command{}),
 	}
 
 	if err := finalize.Run(&sf); err != nil {
@@ -2,14 +2,52 @@ package finalize
 
 import (
 	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
 )
 
+type Stager interface {
+	WriteConfigYml(config interface{}) error
+}
+
 type Finalizer struct {
 	BuildDir string
 	DepDir   string
 	Log      *libbuildpack.Logger
+	Stager   Stager
+	// Supplier generates nginx.conf from nginx.site and validates it like
+	// supply validates the app's own.
+	Supplier *supply.Supplier
 }
 
 func Run(sf *Finalizer) error {
-	return nil
+	if err := sf.Supplier.LoadConfig(sf.BuildDir); err != nil {
+		sf.Log.Error("Could not load buildpack.yml: %s", err.Error())
+		return err
+	}
+
+	generatesConf, err := sf.Supplier.GeneratesConf()
+	if err != nil || !generatesConf {
+		return err
+	}
+
+	if err := sf.Supplier.ReadReport(); err != nil {
+		sf.Log.Error("Could not read staging report: %s", err.Error())
+		return err
+	}
+
+	if err := sf.Supplier.GenerateConf(); err != nil {
+		sf.Log.Error("Could not generate nginx.conf: %s", err.Error())
+		return err
+	}
+
+	if err := sf.Supplier.ValidateNginxConf(); err != nil {
+		sf.Log.Error("Could not validate nginx.conf: %s", err.Error())
+		return err
+	}
+
+	if err := sf.Supplier.WriteReport(); err != nil {
+		sf.Log.Error("Could not write staging report: %s", err.Error())
+		return err
+	}
+	return sf.Stager.WriteConfigYml(sf.Supplier.Report)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/launcher"
+)
+
+func main() {
+	buildpackYMLPath := flag.String("buildpack-yml-path", "", "path to buildpack.yml file")
+	confPath := flag.String("conf", "./nginx.conf", "path to the nginx.conf template")
+	outputDir := flag.String("output-dir", filepath.Join(os.TempDir(), "nginx-rendered"), "directory to render the config into")
+	localModulePath := flag.String("local-modules", "", "path to the user provided modules directory")
+	globalModulePath := flag.String("global-modules", "", "path to the modules shipped with nginx")
+	generatedIncludesPath := flag.String("generated-includes", "", "path to the rules generated from _redirects and _headers")
+	varifyPath := flag.String("varify", "varify", "path to the varify executable")
+	nginxPath := flag.String("nginx", "nginx", "path to the nginx executable")
+	deferredHostsPath := flag.String("deferred-hosts", "", "path to the hosts that did not resolve while staging")
+	resolveTimeout := flag.Duration("resolve-timeout", launcher.DefaultResolveTimeout, "how long to wait for deferred hosts to resolve")
+	flag.Parse()
+
+	prefix, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("Could not determine working directory: %s", err)
+	}
+
+	variants, err := launcher.Variants(*buildpackYMLPath)
+	if err != nil {
+		log.Fatalf("Could not read the config variants: %s", err)
+	}
+	conf, err := launcher.SelectVariant(*confPath, variants)
+	if err != nil {
+		log.Fatalf("Could not select the config: %s", err)
+	}
+	if conf != *confPath {
+		log.Printf("Using %s, selected by %s", conf, launcher.VariantEnv)
+	}
+
+	drainTimeout, err := launcher.DrainTimeout(*buildpackYMLPath)
+	if err != nil {
+		log.Fatalf("Could not determine drain timeout: %s", err)
+	}
+
+	reresolveInterval, err := launcher.ReresolveInterval(*buildpackYMLPath)
+	if err != nil {
+		log.Fatalf("Could not determine re-resolve interval: %s", err)
+	}
+
+	l := launcher.Launcher{
+		VarifyPath:            *varifyPath,
+		NginxPath:             *nginxPath,
+		BuildpackYMLPath:      *buildpackYMLPath,
+		ConfPath:              conf,
+		OutputDir:             *outputDir,
+		LocalModulePath:       *localModulePath,
+		GlobalModulePath:      *globalModulePath,
+		GeneratedIncludesPath: *generatedIncludesPath,
+		Prefix:                prefix,
+		DrainTimeout:          drainTimeout,
+		ReresolveInterval:     reresolveInterval,
+		DeferredHostsPath:     *deferredHostsPath,
+		ResolveTimeout:        *resolveTimeout,
+		Stdout:                os.Stdout,
+		Stderr:                os.Stderr,
+	}
+
+	status, err := l.Run()
+	if err != nil {
+		log.Print(err)
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,99 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// DefaultResolveTimeout is how long the launcher waits for deferred hosts to
+// resolve before starting nginx anyway.
+const DefaultResolveTimeout = 10 * time.Second
+
+// DeferredHost is an upstream host that did not resolve while staging, so
+// that nginx -t could only check the config with a stand-in address.
+type DeferredHost struct {
+	Host string `json:"host"`
+	// Location is the template file and line that use the host.
+	Location string `json:"location"`
+}
+
+// ReadDeferredHosts reads the hosts that supply recorded at path. A missing
+// file means that every host resolved while staging.
+func ReadDeferredHosts(path string) ([]DeferredHost, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	hosts := []DeferredHost{}
+	if err := json.Unmarshal(contents, &hosts); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// WriteDeferredHosts records hosts at path for the launcher to check. The
+// file is removed when there are none.
+func WriteDeferredHosts(path string, hosts []DeferredHost) error {
+	if len(hosts) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	contents, err := json.Marshal(hosts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0644)
+}
+
+// checkDeferredHosts waits up to ResolveTimeout for the hosts that did not
+// resolve while staging and logs the ones that still do not, since nginx
+// refuses to start with them.
+func (l *Launcher) checkDeferredHosts() {
+	if l.DeferredHostsPath == "" {
+		return
+	}
+
+	hosts, err := ReadDeferredHosts(l.DeferredHostsPath)
+	if err != nil {
+		l.logf("could not read deferred hosts: %s", err)
+		return
+	}
+
+	deadline, cancel := context.WithTimeout(context.Background(), l.ResolveTimeout)
+	defer cancel()
+
+	for _, host := range hosts {
+		if !waitForHost(deadline, host.Host) {
+			l.logf("%s, used in %s, does not resolve; nginx will fail to start", host.Host, host.Location)
+		}
+	}
+}
+
+// waitForHost looks up host until it resolves or deadline is done. Every host
+// is looked up at least once, even after the deadline.
+func waitForHost(deadline context.Context, host string) bool {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err == nil {
+			return true
+		}
+
+		select {
+		case <-deadline.Done():
+			return false
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
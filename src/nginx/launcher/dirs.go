@@ -0,0 +1,75 @@
+package launcher
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ReplaceDir moves replacement into place at dir. A directory cannot be
+// renamed over one that has files, so an existing dir is first renamed aside
+// and removed afterwards: dir is only missing between the two renames, never
+// half written.
+func ReplaceDir(dir, replacement string) error {
+	old := ""
+	if _, err := os.Lstat(dir); err == nil {
+		old = filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".old")
+		if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+		if err := os.Rename(dir, old); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(replacement, dir); err != nil {
+		if old != "" {
+			_ = os.Rename(old, dir)
+		}
+		return err
+	}
+	if old != "" {
+		return os.RemoveAll(old)
+	}
+	return nil
+}
+
+// snapshotDir returns the names and contents of the files in dir, and the
+// targets of its symlinks, for comparing two renderings.
+func snapshotDir(dir string) ([]byte, error) {
+	snapshot := &bytes.Buffer{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			snapshot.WriteString(rel + " -> " + target + "\x00")
+		case entry.Type().IsRegular():
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			snapshot.WriteString(rel + "\x00")
+			snapshot.Write(contents)
+			snapshot.WriteString("\x00")
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return snapshot.Bytes(), err
+}
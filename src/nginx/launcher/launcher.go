@@ -0,0 +1,313 @@
+package launcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultDrainTimeout leaves nginx enough time to finish in-flight requests
+// before Cloud Foundry follows up its SIGTERM with a SIGKILL after 10 seconds.
+const DefaultDrainTimeout = 9 * time.Second
+
+type Launcher struct {
+	VarifyPath       string
+	NginxPath        string
+	BuildpackYMLPath string
+	ConfPath         string
+	// OutputDir is where the rendered config is written. The template at
+	// ConfPath is left untouched.
+	OutputDir        string
+	LocalModulePath  string
+	GlobalModulePath string
+	// GeneratedIncludesPath holds the rules generated from _redirects and
+	// _headers while staging, for {{generated_includes}}.
+	GeneratedIncludesPath string
+	Prefix                string
+	DrainTimeout          time.Duration
+	// ReresolveInterval is how often the config is rendered again to pick
+	// up changed upstream addresses. nginx is reloaded when the rendered
+	// config changes. Zero disables re-resolving.
+	ReresolveInterval time.Duration
+	// DeferredHostsPath names the hosts that did not resolve while staging.
+	// They are waited for, up to ResolveTimeout, before nginx starts.
+	DeferredHostsPath string
+	ResolveTimeout    time.Duration
+	Stdout            io.Writer
+	Stderr            io.Writer
+}
+
+type BuildpackYML struct {
+	Nginx struct {
+		DrainTimeout      string `yaml:"drain_timeout"`
+		ReresolveInterval string `yaml:"reresolve_interval"`
+		// Variants names the variants of the config that VariantEnv can
+		// select, such as production for nginx.production.conf.
+		Variants []string `yaml:"variants"`
+	} `yaml:"nginx"`
+}
+
+// Run renders the nginx configuration, starts nginx in the foreground and
+// relays signals to it until it exits. The returned int is the exit status
+// that the launcher process should exit with.
+func (l *Launcher) Run() (int, error) {
+	if err := l.render(l.OutputDir); err != nil {
+		return exitStatus(err), err
+	}
+
+	l.checkDeferredHosts()
+
+	cmd := exec.Command(l.NginxPath, "-p", l.Prefix, "-c", l.RenderedConfPath())
+	cmd.Stdout = l.Stdout
+	cmd.Stderr = l.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return 1, fmt.Errorf("could not start nginx: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	stopWatching := make(chan struct{})
+	stopOnce := func() {
+		select {
+		case <-stopWatching:
+		default:
+			close(stopWatching)
+		}
+	}
+	defer stopOnce()
+
+	reload := make(chan struct{})
+	if l.ReresolveInterval > 0 {
+		go l.watch(stopWatching, reload)
+	}
+
+	var drainExpired <-chan time.Time
+	draining := false
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGTERM {
+				_ = cmd.Process.Signal(sig)
+				continue
+			}
+
+			if draining {
+				l.logf("received second SIGTERM, forcing nginx to shut down")
+				_ = cmd.Process.Signal(syscall.SIGTERM)
+				continue
+			}
+
+			l.logf("received SIGTERM, draining connections for up to %s", l.DrainTimeout)
+			draining = true
+			stopOnce()
+			drainExpired = time.After(l.DrainTimeout)
+			_ = cmd.Process.Signal(syscall.SIGQUIT)
+		case <-reload:
+			l.logf("upstream addresses changed, reloading nginx")
+			_ = cmd.Process.Signal(syscall.SIGHUP)
+		case <-drainExpired:
+			l.logf("drain timeout of %s expired, forcing nginx to shut down", l.DrainTimeout)
+			drainExpired = nil
+			_ = cmd.Process.Signal(syscall.SIGTERM)
+		case err := <-done:
+			return exitStatus(err), nil
+		}
+	}
+}
+
+// RenderedConfPath is the path of the rendered config that nginx is started
+// with.
+func (l *Launcher) RenderedConfPath() string {
+	return filepath.Join(l.OutputDir, filepath.Base(l.ConfPath))
+}
+
+// render renders the config into outputDir.
+func (l *Launcher) render(outputDir string) error {
+	cmd := l.varify("-output-dir", outputDir)
+	cmd.Stdout = l.Stdout
+	cmd.Stderr = l.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not render %s: %w", l.ConfPath, err)
+	}
+
+	return nil
+}
+
+func (l *Launcher) varify(args ...string) *exec.Cmd {
+	if l.GeneratedIncludesPath != "" {
+		args = append([]string{"-generated-includes", l.GeneratedIncludesPath}, args...)
+	}
+	return exec.Command(l.VarifyPath, append([]string{"render",
+		"-conf", l.ConfPath,
+		"-buildpack-yml-path", l.BuildpackYMLPath,
+		"-local-modules", l.LocalModulePath,
+		"-global-modules", l.GlobalModulePath,
+	}, args...)...)
+}
+
+// watch renders the config every ReresolveInterval into a staging dir next
+// to OutputDir and, when it differs from the running config, moves it into
+// place and asks for nginx to be reloaded. Comparing and installing the same
+// rendering keeps a DNS answer that changes in between from being missed. A
+// failed rendering is logged and leaves the running config in place.
+func (l *Launcher) watch(stop <-chan struct{}, reload chan<- struct{}) {
+	ticker := time.NewTicker(l.ReresolveInterval)
+	defer ticker.Stop()
+
+	staging := l.OutputDir + ".next"
+	defer os.RemoveAll(staging)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := l.renderChanges(staging)
+		if err != nil {
+			l.logf("could not re-resolve upstreams: %s", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		select {
+		case reload <- struct{}{}:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// renderChanges renders the config into staging and replaces OutputDir with
+// it if the two differ.
+func (l *Launcher) renderChanges(staging string) (bool, error) {
+	if err := l.render(staging); err != nil {
+		return false, err
+	}
+
+	current, err := snapshotDir(l.OutputDir)
+	if err != nil {
+		return false, err
+	}
+	next, err := snapshotDir(staging)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(current, next) {
+		return false, os.RemoveAll(staging)
+	}
+
+	return true, ReplaceDir(l.OutputDir, staging)
+}
+
+func (l *Launcher) logf(format string, args ...interface{}) {
+	fmt.Fprintf(l.Stderr, "launcher: "+format+"\n", args...)
+}
+
+// DrainTimeout returns the drain timeout configured through the
+// NGINX_DRAIN_TIMEOUT environment variable or the nginx.drain_timeout key in
+// buildpack.yml, in that order of precedence.
+func DrainTimeout(bpYMLPath string) (time.Duration, error) {
+	return readDuration(bpYMLPath, "NGINX_DRAIN_TIMEOUT", "drain timeout", DefaultDrainTimeout, func(bpYML BuildpackYML) string {
+		return bpYML.Nginx.DrainTimeout
+	})
+}
+
+// ReresolveInterval returns the re-resolve interval configured through the
+// NGINX_RERESOLVE_INTERVAL environment variable or the
+// nginx.reresolve_interval key in buildpack.yml, in that order of
+// precedence. It is zero, disabling re-resolving, unless configured.
+func ReresolveInterval(bpYMLPath string) (time.Duration, error) {
+	return readDuration(bpYMLPath, "NGINX_RERESOLVE_INTERVAL", "re-resolve interval", 0, func(bpYML BuildpackYML) string {
+		return bpYML.Nginx.ReresolveInterval
+	})
+}
+
+func readDuration(bpYMLPath, envName, name string, defaultValue time.Duration, field func(BuildpackYML) string) (time.Duration, error) {
+	if value := os.Getenv(envName); value != "" {
+		return parseDuration(name, value)
+	}
+
+	bpYML, err := readBuildpackYML(bpYMLPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if field(bpYML) == "" {
+		return defaultValue, nil
+	}
+
+	return parseDuration(name, field(bpYML))
+}
+
+// readBuildpackYML reads the buildpack.yml at bpYMLPath, which may be empty
+// or not exist.
+func readBuildpackYML(bpYMLPath string) (BuildpackYML, error) {
+	var bpYML BuildpackYML
+	if bpYMLPath == "" {
+		return bpYML, nil
+	}
+
+	exists, err := libbuildpack.FileExists(bpYMLPath)
+	if err != nil || !exists {
+		return bpYML, err
+	}
+
+	contents, err := os.ReadFile(bpYMLPath)
+	if err != nil {
+		return bpYML, err
+	}
+
+	err = yaml.Unmarshal(contents, &bpYML)
+	return bpYML, err
+}
+
+func parseDuration(name, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, value)
+	}
+
+	return duration, nil
+}
+
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,25 @@
+package launcher_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+)
+
+func TestLauncher(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Launcher Suite")
+}
+
+var pathToCli string
+var _ = BeforeSuite(func() {
+	var err error
+	pathToCli, err = gexec.Build("github.com/cloudfoundry/nginx-buildpack/src/nginx/launcher/cli")
+	Expect(err).ToNot(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -0,0 +1,365 @@
+package launcher_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/launcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("launcher", func() {
+	var (
+		tmpDir, signalLog, readyFile, varifyPath, nginxPath string
+	)
+
+	writeScript := func(name, body string) string {
+		path := filepath.Join(tmpDir, name)
+		Expect(os.WriteFile(path, []byte("#!/usr/bin/env bash\n"+body), 0755)).To(Succeed())
+		return path
+	}
+
+	start := func(env ...string) *gexec.Session {
+		command := exec.Command(pathToCli,
+			"-varify", varifyPath,
+			"-nginx", nginxPath,
+			"-conf", filepath.Join(tmpDir, "nginx.conf"),
+			"-output-dir", filepath.Join(tmpDir, "rendered"),
+		)
+		command.Dir = tmpDir
+		command.Env = append(os.Environ(), append([]string{"SIGNAL_LOG=" + signalLog, "READY=" + readyFile}, env...)...)
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		return session
+	}
+
+	waitUntilReady := func() {
+		Eventually(func() error {
+			_, err := os.Stat(readyFile)
+			return err
+		}, "5s").Should(Succeed())
+	}
+
+	signals := func() string {
+		contents, _ := os.ReadFile(signalLog)
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "nginx.launcher")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(os.RemoveAll, tmpDir)
+
+		signalLog = filepath.Join(tmpDir, "signals")
+		readyFile = filepath.Join(tmpDir, "ready")
+		varifyPath = writeScript("varify", `echo "rendered $@" > "$(dirname "$0")/varify.log"`)
+		nginxPath = writeScript("nginx", `
+echo "$@" > "$(dirname "$0")/nginx.log"
+trap 'echo QUIT >> "$SIGNAL_LOG"; exit 0' QUIT
+trap 'echo TERM >> "$SIGNAL_LOG"; exit 7' TERM
+trap 'echo HUP >> "$SIGNAL_LOG"' HUP
+touch "$READY"
+while true; do sleep 0.05; done
+`)
+	})
+
+	It("renders the config with varify before starting nginx", func() {
+		session := start()
+		waitUntilReady()
+
+		contents, err := os.ReadFile(filepath.Join(tmpDir, "varify.log"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(ContainSubstring("render -conf " + filepath.Join(tmpDir, "nginx.conf")))
+		Expect(string(contents)).To(ContainSubstring("-output-dir " + filepath.Join(tmpDir, "rendered")))
+
+		session.Terminate()
+		Eventually(session, "5s").Should(gexec.Exit(0))
+	})
+
+	It("starts nginx with the rendered config", func() {
+		session := start()
+		waitUntilReady()
+
+		contents, err := os.ReadFile(filepath.Join(tmpDir, "nginx.log"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal("-p " + tmpDir + " -c " + filepath.Join(tmpDir, "rendered", "nginx.conf") + "\n"))
+
+		session.Terminate()
+		Eventually(session, "5s").Should(gexec.Exit(0))
+	})
+
+	It("turns SIGTERM into a graceful SIGQUIT", func() {
+		session := start()
+		waitUntilReady()
+
+		session.Terminate()
+		Eventually(session, "5s").Should(gexec.Exit(0))
+		Expect(signals()).To(Equal("QUIT\n"))
+	})
+
+	It("forwards other signals unchanged", func() {
+		session := start()
+		waitUntilReady()
+
+		session.Signal(syscall.SIGHUP)
+		Eventually(signals, "5s").Should(Equal("HUP\n"))
+
+		session.Terminate()
+		Eventually(session, "5s").Should(gexec.Exit(0))
+	})
+
+	It("forces a fast shutdown once the drain timeout expires", func() {
+		nginxPath = writeScript("nginx", `
+echo "$@" > "$(dirname "$0")/nginx.log"
+trap 'echo QUIT >> "$SIGNAL_LOG"' QUIT
+trap 'echo TERM >> "$SIGNAL_LOG"; exit 7' TERM
+touch "$READY"
+while true; do sleep 0.05; done
+`)
+		session := start("NGINX_DRAIN_TIMEOUT=200ms")
+		waitUntilReady()
+
+		session.Terminate()
+		Eventually(session, "5s").Should(gexec.Exit(7))
+		Expect(signals()).To(Equal("QUIT\nTERM\n"))
+		Expect(session.Err).To(gbytes.Say("drain timeout of 200ms expired"))
+	})
+
+	It("passes on the exit status of nginx", func() {
+		nginxPath = writeScript("nginx", "exit 3")
+		session := start()
+		Eventually(session, "5s").Should(gexec.Exit(3))
+	})
+
+	It("does not start nginx when rendering fails", func() {
+		varifyPath = writeScript("varify", "exit 2")
+		session := start()
+		Eventually(session, "5s").Should(gexec.Exit(2))
+		Expect(readyFile).NotTo(BeAnExistingFile())
+	})
+
+	Describe("deferred hosts", func() {
+		It("warns about hosts from staging that still do not resolve", func() {
+			deferredHostsPath := filepath.Join(tmpDir, "deferred-hosts.json")
+			Expect(launcher.WriteDeferredHosts(deferredHostsPath, []launcher.DeferredHost{
+				{Host: "localhost", Location: "nginx.conf:3"},
+				{Host: "backend.nginx-buildpack.invalid", Location: "sites/app.conf:12"},
+			})).To(Succeed())
+
+			command := exec.Command(pathToCli,
+				"-varify", varifyPath,
+				"-nginx", nginxPath,
+				"-conf", filepath.Join(tmpDir, "nginx.conf"),
+				"-output-dir", filepath.Join(tmpDir, "rendered"),
+				"-deferred-hosts", deferredHostsPath,
+				"-resolve-timeout", "300ms",
+			)
+			command.Dir = tmpDir
+			command.Env = append(os.Environ(), "SIGNAL_LOG="+signalLog, "READY="+readyFile)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			waitUntilReady()
+
+			Expect(session.Err).To(gbytes.Say(`backend.nginx-buildpack.invalid, used in sites/app.conf:12, does not resolve; nginx will fail to start`))
+			Expect(session.Err.Contents()).NotTo(ContainSubstring("localhost"))
+
+			session.Terminate()
+			Eventually(session, "5s").Should(gexec.Exit(0))
+		})
+
+		It("removes the record when no host was deferred", func() {
+			deferredHostsPath := filepath.Join(tmpDir, "deferred-hosts.json")
+			Expect(launcher.WriteDeferredHosts(deferredHostsPath, []launcher.DeferredHost{{Host: "localhost"}})).To(Succeed())
+			Expect(launcher.WriteDeferredHosts(deferredHostsPath, nil)).To(Succeed())
+			Expect(deferredHostsPath).NotTo(BeAnExistingFile())
+			Expect(launcher.ReadDeferredHosts(deferredHostsPath)).To(BeEmpty())
+		})
+	})
+
+	Describe("re-resolving upstreams", func() {
+		BeforeEach(func() {
+			varifyPath = writeScript("varify", `
+dir="$(dirname "$0")"
+while [[ $# -gt 0 ]]; do
+  if [[ $1 == -output-dir ]]; then out="$2"; fi
+  shift
+done
+rm -rf "$out" && mkdir -p "$out"
+cp "$dir/upstreams" "$out/nginx.conf"
+`)
+			Expect(os.WriteFile(filepath.Join(tmpDir, "upstreams"), []byte("server 10.255.0.1:8080;\n"), 0644)).To(Succeed())
+		})
+
+		rendered := func() string {
+			contents, _ := os.ReadFile(filepath.Join(tmpDir, "rendered", "nginx.conf"))
+			return string(contents)
+		}
+
+		It("does not reload nginx while the rendered config is unchanged", func() {
+			session := start("NGINX_RERESOLVE_INTERVAL=50ms")
+			waitUntilReady()
+
+			Consistently(signals, "300ms").Should(BeEmpty())
+			Expect(rendered()).To(Equal("server 10.255.0.1:8080;\n"))
+
+			session.Terminate()
+			Eventually(session, "5s").Should(gexec.Exit(0))
+		})
+
+		It("renders again and reloads nginx when the upstreams change", func() {
+			session := start("NGINX_RERESOLVE_INTERVAL=50ms")
+			waitUntilReady()
+
+			Expect(os.WriteFile(filepath.Join(tmpDir, "upstreams"), []byte("server 10.255.0.2:8080;\n"), 0644)).To(Succeed())
+			Eventually(signals, "5s").Should(Equal("HUP\n"))
+			Expect(rendered()).To(Equal("server 10.255.0.2:8080;\n"))
+			Expect(session.Err).To(gbytes.Say("upstream addresses changed, reloading nginx"))
+
+			session.Terminate()
+			Eventually(session, "5s").Should(gexec.Exit(0))
+		})
+
+		It("does not re-resolve unless configured", func() {
+			session := start()
+			waitUntilReady()
+
+			Expect(os.WriteFile(filepath.Join(tmpDir, "upstreams"), []byte("server 10.255.0.2:8080;\n"), 0644)).To(Succeed())
+			Consistently(signals, "300ms").Should(BeEmpty())
+
+			session.Terminate()
+			Eventually(session, "5s").Should(gexec.Exit(0))
+		})
+	})
+
+	Describe("ReplaceDir", func() {
+		It("moves the replacement into place and removes the previous dir", func() {
+			dir := filepath.Join(tmpDir, "rendered")
+			next := filepath.Join(tmpDir, "rendered.next")
+			Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "stale.conf"), nil, 0644)).To(Succeed())
+			Expect(os.MkdirAll(next, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(next, "nginx.conf"), []byte("events {}"), 0644)).To(Succeed())
+
+			Expect(launcher.ReplaceDir(dir, next)).To(Succeed())
+
+			Expect(filepath.Join(dir, "nginx.conf")).To(BeAnExistingFile())
+			Expect(filepath.Join(dir, "stale.conf")).NotTo(BeAnExistingFile())
+			Expect(next).NotTo(BeADirectory())
+			Expect(filepath.Join(tmpDir, ".rendered.old")).NotTo(BeADirectory())
+		})
+
+		It("moves the replacement into place when there is no previous dir", func() {
+			next := filepath.Join(tmpDir, "rendered.next")
+			Expect(os.MkdirAll(next, 0755)).To(Succeed())
+
+			Expect(launcher.ReplaceDir(filepath.Join(tmpDir, "rendered"), next)).To(Succeed())
+			Expect(filepath.Join(tmpDir, "rendered")).To(BeADirectory())
+		})
+	})
+
+	Describe("ReresolveInterval", func() {
+		It("is disabled when nothing is configured", func() {
+			Expect(launcher.ReresolveInterval(filepath.Join(tmpDir, "buildpack.yml"))).To(BeZero())
+		})
+
+		It("reads nginx.reresolve_interval from buildpack.yml", func() {
+			bpYMLPath := filepath.Join(tmpDir, "buildpack.yml")
+			Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  reresolve_interval: 30s\n"), 0644)).To(Succeed())
+			Expect(launcher.ReresolveInterval(bpYMLPath)).To(Equal(30 * time.Second))
+		})
+
+		It("rejects invalid durations", func() {
+			GinkgoT().Setenv("NGINX_RERESOLVE_INTERVAL", "often")
+			_, err := launcher.ReresolveInterval("")
+			Expect(err).To(MatchError(ContainSubstring(`invalid re-resolve interval "often"`)))
+		})
+	})
+
+	Describe("config variants", func() {
+		var confPath string
+		variants := []string{"production", "staging"}
+
+		BeforeEach(func() {
+			confPath = filepath.Join(tmpDir, "nginx.conf")
+			for _, name := range []string{"nginx.conf", "nginx.production.conf", "nginx.staging.conf", "nginx.ssl.conf", "mime.types"} {
+				Expect(os.WriteFile(filepath.Join(tmpDir, name), nil, 0644)).To(Succeed())
+			}
+		})
+
+		It("reads the variants from nginx.variants in buildpack.yml", func() {
+			bpYMLPath := filepath.Join(tmpDir, "buildpack.yml")
+			Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  variants: [production, staging]\n"), 0644)).To(Succeed())
+			Expect(launcher.Variants(bpYMLPath)).To(Equal(variants))
+		})
+
+		It("has no variants unless buildpack.yml lists them", func() {
+			Expect(launcher.Variants(filepath.Join(tmpDir, "buildpack.yml"))).To(BeEmpty())
+		})
+
+		It("rejects variants that are not a plain name", func() {
+			bpYMLPath := filepath.Join(tmpDir, "buildpack.yml")
+			Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  variants: [../other]\n"), 0644)).To(Succeed())
+			_, err := launcher.Variants(bpYMLPath)
+			Expect(err).To(MatchError(`invalid variant "../other" in nginx.variants, expected a name such as production`))
+		})
+
+		It("runs the config when no variant is selected", func() {
+			Expect(launcher.SelectVariant(confPath, variants)).To(Equal(confPath))
+		})
+
+		It("runs the variant that NGINX_CONFIG_VARIANT selects", func() {
+			GinkgoT().Setenv("NGINX_CONFIG_VARIANT", "staging")
+			Expect(launcher.SelectVariant(confPath, variants)).To(Equal(filepath.Join(tmpDir, "nginx.staging.conf")))
+		})
+
+		It("fails on a variant that is not listed", func() {
+			GinkgoT().Setenv("NGINX_CONFIG_VARIANT", "ssl")
+			_, err := launcher.SelectVariant(confPath, variants)
+			Expect(err).To(MatchError(ContainSubstring(`NGINX_CONFIG_VARIANT selects variant "ssl" of ` + confPath + `, which is not in nginx.variants; the variants are production, staging`)))
+		})
+
+		It("runs the config when there are no variants whatever is selected", func() {
+			GinkgoT().Setenv("NGINX_CONFIG_VARIANT", "ssl")
+			Expect(launcher.SelectVariant(confPath, nil)).To(Equal(confPath))
+		})
+
+		It("runs a config without the variant whatever is selected", func() {
+			GinkgoT().Setenv("NGINX_CONFIG_VARIANT", "staging")
+			streamConf := filepath.Join(tmpDir, "stream.conf")
+			Expect(launcher.SelectVariant(streamConf, variants)).To(Equal(streamConf))
+		})
+	})
+
+	Describe("DrainTimeout", func() {
+		It("defaults when nothing is configured", func() {
+			Expect(launcher.DrainTimeout(filepath.Join(tmpDir, "buildpack.yml"))).To(Equal(launcher.DefaultDrainTimeout))
+		})
+
+		It("reads nginx.drain_timeout from buildpack.yml", func() {
+			bpYMLPath := filepath.Join(tmpDir, "buildpack.yml")
+			Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  drain_timeout: 20s\n"), 0644)).To(Succeed())
+			Expect(launcher.DrainTimeout(bpYMLPath)).To(Equal(20 * time.Second))
+		})
+
+		It("prefers NGINX_DRAIN_TIMEOUT over buildpack.yml", func() {
+			bpYMLPath := filepath.Join(tmpDir, "buildpack.yml")
+			Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  drain_timeout: 20s\n"), 0644)).To(Succeed())
+			GinkgoT().Setenv("NGINX_DRAIN_TIMEOUT", "3s")
+			Expect(launcher.DrainTimeout(bpYMLPath)).To(Equal(3 * time.Second))
+		})
+
+		It("rejects invalid durations", func() {
+			GinkgoT().Setenv("NGINX_DRAIN_TIMEOUT", "soon")
+			_, err := launcher.DrainTimeout("")
+			Expect(err).To(MatchError(ContainSubstring(`invalid drain timeout "soon"`)))
+		})
+	})
+})
@@ -0,0 +1,75 @@
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// VariantEnv names the variable that selects a variant of the config at
+// runtime, such as production for nginx.production.conf next to nginx.conf.
+const VariantEnv = "NGINX_CONFIG_VARIANT"
+
+var variantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// VariantPath returns the path of the variant of the config at confPath.
+func VariantPath(confPath, variant string) string {
+	ext := filepath.Ext(confPath)
+	return strings.TrimSuffix(confPath, ext) + "." + variant + ext
+}
+
+// ValidateVariant checks that variant names a file next to the config
+// rather than one in another directory.
+func ValidateVariant(variant string) error {
+	if !variantPattern.MatchString(variant) {
+		return fmt.Errorf("invalid variant %q in nginx.variants, expected a name such as production", variant)
+	}
+	return nil
+}
+
+// Variants returns the variants of the config listed in nginx.variants in
+// the buildpack.yml at bpYMLPath. Only the files listed there are variants,
+// so that files such as nginx.ssl.conf can be included by nginx.conf.
+func Variants(bpYMLPath string) ([]string, error) {
+	bpYML, err := readBuildpackYML(bpYMLPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, variant := range bpYML.Nginx.Variants {
+		if err := ValidateVariant(variant); err != nil {
+			return nil, err
+		}
+	}
+	return bpYML.Nginx.Variants, nil
+}
+
+// SelectVariant returns the config to run instead of confPath: the variant
+// that VariantEnv names, or confPath itself when VariantEnv is not set, there
+// are no variants or confPath has no file for the variant, as for configs
+// that other process types run. A variant that is not in variants is an
+// error.
+func SelectVariant(confPath string, variants []string) (string, error) {
+	variant := os.Getenv(VariantEnv)
+	if variant == "" || len(variants) == 0 {
+		return confPath, nil
+	}
+
+	found := false
+	for _, v := range variants {
+		found = found || v == variant
+	}
+	if !found {
+		return "", fmt.Errorf("%s selects variant %q of %s, which is not in nginx.variants; the variants are %s", VariantEnv, variant, confPath, strings.Join(variants, ", "))
+	}
+
+	path := VariantPath(confPath, variant)
+	if exists, err := libbuildpack.FileExists(path); err != nil || !exists {
+		return confPath, err
+	}
+	return path, nil
+}
@@ -0,0 +1,182 @@
+// Package lint checks nginx configs for mistakes that nginx accepts but that
+// break or hamper an app running in a container.
+package lint
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+)
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Rule is a single check. Check reports a Problem for every mistake it finds;
+// the rule's ID, Severity and Hint are added to each of them.
+type Rule struct {
+	ID       string
+	Severity Severity
+	// Hint tells the user how to fix the problems the rule reports.
+	Hint  string
+	Check func(c *Context) []Problem
+}
+
+// Problem is a mistake found by a rule. Pos is the zero Position when the
+// mistake is something missing from the config.
+type Problem struct {
+	Pos     nginxconf.Position
+	Message string
+}
+
+// Finding is a Problem together with the rule that reported it.
+type Finding struct {
+	Problem
+	RuleID   string
+	Severity Severity
+	Hint     string
+}
+
+func (f Finding) String() string {
+	if f.Pos.File == "" {
+		return fmt.Sprintf("%s [%s]", f.Message, f.RuleID)
+	}
+	if f.Pos.Line == 0 {
+		return fmt.Sprintf("%s: %s [%s]", f.Pos.File, f.Message, f.RuleID)
+	}
+	return fmt.Sprintf("%s:%d: %s [%s]", f.Pos.File, f.Pos.Line, f.Message, f.RuleID)
+}
+
+// Context is what the rules check.
+type Context struct {
+	// Confs holds the main config first, followed by the files it includes.
+	Confs []*nginxconf.Config
+	// AppDir is where the app lives at runtime.
+	AppDir string
+	// StagingDirs are directories that exist while staging but not at
+	// runtime.
+	StagingDirs []string
+}
+
+// Walk calls fn for every directive of every config, in the order of
+// nginxconf.Config.Walk. Template actions in statement position are skipped.
+func (c *Context) Walk(fn func(d *nginxconf.Directive, parents []*nginxconf.Directive)) {
+	for _, conf := range c.Confs {
+		conf.Walk(func(d *nginxconf.Directive, parents []*nginxconf.Directive) bool {
+			if !d.Template {
+				fn(d, parents)
+			}
+			return true
+		})
+	}
+}
+
+// Find returns every directive with the given name in every config.
+func (c *Context) Find(name string) []*nginxconf.Directive {
+	found := []*nginxconf.Directive{}
+	for _, conf := range c.Confs {
+		found = append(found, conf.Find(name)...)
+	}
+	return found
+}
+
+// Config selects and tunes the rules. It is read from the nginx.lint key of
+// buildpack.yml, which is either the string "strict" or a map such as
+// {strict: true, disable: [worker-processes-auto]}.
+type Config struct {
+	// Strict turns warnings into errors.
+	Strict  bool     `yaml:"strict"`
+	Disable []string `yaml:"disable"`
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mode string
+	if err := unmarshal(&mode); err == nil {
+		if mode != "strict" {
+			return fmt.Errorf("invalid nginx.lint %q, expected strict or a map", mode)
+		}
+		c.Strict = true
+		return nil
+	}
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// Run checks c with every rule that config does not disable and returns the
+// findings in the order of c.Confs and then by line, with findings about
+// missing directives first. It fails when config disables a rule that is not
+// in rules.
+func Run(c *Context, rules []Rule, config Config) ([]Finding, error) {
+	known := map[string]bool{}
+	for _, rule := range rules {
+		known[rule.ID] = true
+	}
+	for _, id := range config.Disable {
+		if !known[id] {
+			return nil, fmt.Errorf("unknown lint rule %q", id)
+		}
+	}
+
+	findings := []Finding{}
+	for _, rule := range rules {
+		if slices.Contains(config.Disable, rule.ID) {
+			continue
+		}
+
+		severity := rule.Severity
+		if config.Strict {
+			severity = Error
+		}
+
+		for _, problem := range rule.Check(c) {
+			findings = append(findings, Finding{Problem: problem, RuleID: rule.ID, Severity: severity, Hint: rule.Hint})
+		}
+	}
+
+	order := map[string]int{"": -1}
+	for i, conf := range c.Confs {
+		order[conf.File] = i
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i].Pos, findings[j].Pos
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return findings, nil
+}
+
+// HasErrors reports whether any of findings is an error.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// RelativeTo rewrites the file names of findings relative to dir.
+func RelativeTo(dir string, findings []Finding) {
+	for i := range findings {
+		if rel, err := filepath.Rel(dir, findings[i].Pos.File); err == nil && !strings.HasPrefix(rel, "..") {
+			findings[i].Pos.File = rel
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package lint_test
+
+import (
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/lint"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+	"gopkg.in/yaml.v2"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const goodConf = `
+daemon off;
+error_log stderr;
+worker_processes {{auto_worker_processes}};
+pid logs/nginx.pid;
+http {
+  access_log /dev/stdout;
+  server { listen {{port}}; }
+}
+`
+
+var _ = Describe("lint", func() {
+	context := func(srcs ...string) *lint.Context {
+		c := &lint.Context{AppDir: "/home/vcap/app", StagingDirs: []string{"/tmp/app"}}
+		names := []string{"nginx.conf", "site.conf"}
+		for i, src := range srcs {
+			conf, err := nginxconf.Parse(names[i], []byte(src))
+			Expect(err).NotTo(HaveOccurred())
+			c.Confs = append(c.Confs, conf)
+		}
+		return c
+	}
+
+	run := func(config lint.Config, srcs ...string) []string {
+		findings, err := lint.Run(context(srcs...), lint.Rules, config)
+		Expect(err).NotTo(HaveOccurred())
+		result := []string{}
+		for _, f := range findings {
+			result = append(result, f.Severity.String()+" "+f.String())
+		}
+		return result
+	}
+
+	It("finds nothing in a config that suits a container", func() {
+		Expect(run(lint.Config{}, goodConf)).To(BeEmpty())
+	})
+
+	It("reports a missing daemon off as an error", func() {
+		Expect(run(lint.Config{}, "error_log stderr; access_log /dev/stdout;")).To(Equal([]string{
+			"error `daemon off;` is missing, nginx runs in the background and the app stops as soon as it starts [daemon-off]",
+		}))
+		Expect(run(lint.Config{}, "daemon on; error_log stderr; access_log /dev/stdout;")).To(Equal([]string{
+			"error nginx.conf:1: daemon on runs nginx in the background, the app stops as soon as it starts [daemon-off]",
+		}))
+	})
+
+	It("finds directives in included files", func() {
+		Expect(run(lint.Config{}, "include site.conf; access_log /dev/stdout;", "daemon off;\nerror_log stderr;")).To(BeEmpty())
+	})
+
+	It("reports the mistakes with their location, in source order", func() {
+		Expect(run(lint.Config{}, goodConf+`
+user www-data;
+worker_processes auto;
+error_log logs/error.log;
+pid /var/run/nginx.pid;
+http {
+  client_body_temp_path /home/vcap/app/tmp;
+  server { listen 80; listen [::]:443; listen 127.0.0.1; listen 8080; listen unix:/tmp/nginx.sock; }
+  server { listen {{port}}; root /tmp/app/public; }
+}
+`)).To(Equal([]string{
+			"warning nginx.conf:11: the user directive has no effect since nginx does not run as root [user-directive]",
+			"warning nginx.conf:12: worker_processes auto starts a worker for every CPU of the host, not of the container [worker-processes-auto]",
+			"warning nginx.conf:13: error_log writes to the file logs/error.log, which is not part of the app logs [error-log-stderr]",
+			"warning nginx.conf:14: pid /var/run/nginx.pid is outside the app directory /home/vcap/app [path-outside-app]",
+			"warning nginx.conf:17: listen 80 uses port 80, which the app is not allowed to bind [privileged-port]",
+			"warning nginx.conf:17: listen [::]:443 uses port 443, which the app is not allowed to bind [privileged-port]",
+			"warning nginx.conf:17: listen 127.0.0.1 uses port 80, which the app is not allowed to bind [privileged-port]",
+			"error nginx.conf:18: /tmp/app/public points into /tmp/app, which only exists while staging [staging-path]",
+		}))
+	})
+
+	It("warns when access logging is off", func() {
+		Expect(run(lint.Config{}, "daemon off; access_log off;")).To(Equal([]string{
+			"warning access logging is turned off in your nginx.conf file, this may make your app difficult to debug. [access-log]",
+		}))
+	})
+
+	It("skips arguments that are only known at runtime", func() {
+		Expect(run(lint.Config{}, goodConf+`error_log {{env "ERROR_LOG"}}; daemon {{env "DAEMON"}};`)).To(BeEmpty())
+	})
+
+	It("skips disabled rules", func() {
+		Expect(run(lint.Config{Disable: []string{"worker-processes-auto"}}, goodConf+"worker_processes auto;")).To(BeEmpty())
+	})
+
+	It("turns warnings into errors in strict mode", func() {
+		Expect(run(lint.Config{Strict: true}, goodConf+"user nobody;")).To(Equal([]string{
+			"error nginx.conf:10: the user directive has no effect since nginx does not run as root [user-directive]",
+		}))
+	})
+
+	It("fails on unknown rules", func() {
+		_, err := lint.Run(context(goodConf), lint.Rules, lint.Config{Disable: []string{"no-such-rule"}})
+		Expect(err).To(MatchError(`unknown lint rule "no-such-rule"`))
+	})
+
+	Describe("Config", func() {
+		parse := func(src string) (lint.Config, error) {
+			var config struct {
+				Lint lint.Config `yaml:"lint"`
+			}
+			err := yaml.Unmarshal([]byte(src), &config)
+			return config.Lint, err
+		}
+
+		It("accepts strict as a shorthand", func() {
+			Expect(parse("lint: strict")).To(Equal(lint.Config{Strict: true}))
+		})
+
+		It("accepts a map", func() {
+			Expect(parse("lint:\n  disable: [user-directive]\n")).To(Equal(lint.Config{Disable: []string{"user-directive"}}))
+		})
+
+		It("rejects other strings", func() {
+			_, err := parse("lint: loose")
+			Expect(err).To(MatchError(ContainSubstring(`invalid nginx.lint "loose"`)))
+		})
+	})
+})
@@ -0,0 +1,218 @@
+package lint
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+)
+
+// Rules are the rules run while staging.
+var Rules = []Rule{
+	AccessLog,
+	DaemonOff,
+	ErrorLogStderr,
+	WorkerProcessesAuto,
+	UserDirective,
+	PathOutsideApp,
+	StagingPath,
+	PrivilegedPort,
+}
+
+var AccessLog = Rule{
+	ID:       "access-log",
+	Severity: Warning,
+	Hint:     "Add an access_log directive that writes to /dev/stdout.",
+	Check: func(c *Context) []Problem {
+		directives := c.Find("access_log")
+		for _, d := range directives {
+			if !strings.EqualFold(d.Arg(0), "off") {
+				return nil
+			}
+		}
+		return []Problem{{Message: "access logging is turned off in your nginx.conf file, this may make your app difficult to debug."}}
+	},
+}
+
+var DaemonOff = Rule{
+	ID:       "daemon-off",
+	Severity: Error,
+	Hint:     "Add `daemon off;` to the main context so that nginx stays in the foreground.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		found := false
+		for _, d := range c.Find("daemon") {
+			found = true
+			if isLiteral(d, 0) && !strings.EqualFold(d.Arg(0), "off") {
+				problems = append(problems, Problem{Pos: d.Pos, Message: fmt.Sprintf("daemon %s runs nginx in the background, the app stops as soon as it starts", d.Arg(0))})
+			}
+		}
+		if !found {
+			problems = append(problems, Problem{Message: "`daemon off;` is missing, nginx runs in the background and the app stops as soon as it starts"})
+		}
+		return problems
+	},
+}
+
+var ErrorLogStderr = Rule{
+	ID:       "error-log-stderr",
+	Severity: Warning,
+	Hint:     "Use `error_log stderr;` so that errors show up in the app logs.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		for _, d := range c.Find("error_log") {
+			if !isLiteral(d, 0) {
+				continue
+			}
+			switch target := d.Arg(0); {
+			case target == "stderr", target == "/dev/stderr", target == "/dev/stdout",
+				strings.HasPrefix(target, "syslog:"), strings.HasPrefix(target, "memory:"):
+			default:
+				problems = append(problems, Problem{Pos: d.Pos, Message: fmt.Sprintf("error_log writes to the file %s, which is not part of the app logs", target)})
+			}
+		}
+		return problems
+	},
+}
+
+var WorkerProcessesAuto = Rule{
+	ID:       "worker-processes-auto",
+	Severity: Warning,
+	Hint:     "Use `worker_processes {{auto_worker_processes}};` to size the workers to the container.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		for _, d := range c.Find("worker_processes") {
+			if isLiteral(d, 0) && d.Arg(0) == "auto" {
+				problems = append(problems, Problem{Pos: d.Pos, Message: "worker_processes auto starts a worker for every CPU of the host, not of the container"})
+			}
+		}
+		return problems
+	},
+}
+
+var UserDirective = Rule{
+	ID:       "user-directive",
+	Severity: Warning,
+	Hint:     "Remove the user directive.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		for _, d := range c.Find("user") {
+			problems = append(problems, Problem{Pos: d.Pos, Message: "the user directive has no effect since nginx does not run as root"})
+		}
+		return problems
+	},
+}
+
+// appPathDirectives name files nginx writes to, which must be in a directory
+// the app may write to.
+var appPathDirectives = []string{
+	"pid",
+	"client_body_temp_path",
+	"proxy_temp_path",
+	"fastcgi_temp_path",
+	"uwsgi_temp_path",
+	"scgi_temp_path",
+}
+
+var PathOutsideApp = Rule{
+	ID:       "path-outside-app",
+	Severity: Warning,
+	Hint:     "Use a relative path, which nginx resolves against the app directory.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		for _, name := range appPathDirectives {
+			for _, d := range c.Find(name) {
+				path := d.Arg(0)
+				if !isLiteral(d, 0) || !filepath.IsAbs(path) || within(path, c.AppDir) {
+					continue
+				}
+				problems = append(problems, Problem{Pos: d.Pos, Message: fmt.Sprintf("%s %s is outside the app directory %s", d.Name, path, c.AppDir)})
+			}
+		}
+		return problems
+	},
+}
+
+var StagingPath = Rule{
+	ID:       "staging-path",
+	Severity: Error,
+	Hint:     "Use a relative path, which nginx resolves against the app directory.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		c.Walk(func(d *nginxconf.Directive, _ []*nginxconf.Directive) {
+			for _, arg := range d.Args {
+				for _, dir := range c.StagingDirs {
+					if within(c.AppDir, dir) {
+						continue
+					}
+					if !arg.HasTemplate() && filepath.IsAbs(arg.Value) && within(arg.Value, dir) {
+						problems = append(problems, Problem{Pos: arg.Pos, Message: fmt.Sprintf("%s points into %s, which only exists while staging", arg.Value, dir)})
+						break
+					}
+				}
+			}
+		})
+		return problems
+	},
+}
+
+var PrivilegedPort = Rule{
+	ID:       "privileged-port",
+	Severity: Warning,
+	Hint:     "Listen on `{{port}}` instead.",
+	Check: func(c *Context) []Problem {
+		problems := []Problem{}
+		for _, d := range c.Find("listen") {
+			if !isLiteral(d, 0) {
+				continue
+			}
+			if port, ok := listenPort(d.Arg(0)); ok && port < 1024 {
+				problems = append(problems, Problem{Pos: d.Pos, Message: fmt.Sprintf("listen %s uses port %d, which the app is not allowed to bind", d.Arg(0), port)})
+			}
+		}
+		return problems
+	},
+}
+
+// isLiteral reports whether the i-th argument of d is known while staging.
+func isLiteral(d *nginxconf.Directive, i int) bool {
+	return i < len(d.Args) && !d.Args[i].HasTemplate()
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// listenPort returns the port of the address argument of a listen
+// directive, which defaults to 80 when only a host is given.
+func listenPort(address string) (int, bool) {
+	if strings.HasPrefix(address, "unix:") {
+		return 0, false
+	}
+
+	port := address
+	if i := strings.LastIndex(address, ":"); i >= 0 && i > strings.LastIndex(address, "]") {
+		port = address[i+1:]
+	} else if !isDigits(address) {
+		return 80, true
+	}
+
+	n, err := strconv.Atoi(port)
+	return n, err == nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
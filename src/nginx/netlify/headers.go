@@ -0,0 +1,121 @@
+package netlify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
+)
+
+// HeaderRule is a path pattern of _headers with the headers that responses
+// for it get.
+type HeaderRule struct {
+	Line    int
+	Path    string
+	Headers []Header
+}
+
+type Header struct {
+	Name  string
+	Value string
+}
+
+// ParseHeaders parses the _headers file in contents, which file names in
+// findings. A path starts a line and the headers for it follow, indented.
+func ParseHeaders(file string, contents []byte) ([]HeaderRule, []Finding) {
+	rules := []HeaderRule{}
+	findings := []Finding{}
+	var current *HeaderRule
+	skipping := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		finding := func(format string, args ...interface{}) {
+			findings = append(findings, Finding{File: file, Line: line, Msg: fmt.Sprintf(format, args...)})
+		}
+
+		if text[0] != ' ' && text[0] != '\t' {
+			current = nil
+			skipping = true
+			switch {
+			case !strings.HasPrefix(trimmed, "/"):
+				finding("headers for other domains, such as %s, are not supported", trimmed)
+			case strings.ContainsAny(trimmed, unsafeChars):
+				finding("%s has characters that cannot be written to nginx.conf", trimmed)
+			default:
+				rules = append(rules, HeaderRule{Line: line, Path: trimmed})
+				current = &rules[len(rules)-1]
+				skipping = false
+			}
+			continue
+		}
+
+		if current == nil {
+			if !skipping {
+				finding("expected a path before the header %s", trimmed)
+			}
+			continue
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case !ok || !site.ValidHeaderName(name):
+			finding("expected a header such as X-Frame-Options: DENY, not %s", trimmed)
+		case strings.Contains(value, "$"):
+			finding("the value of header %s has a $, which nginx cannot send as it is", name)
+		default:
+			current.Headers = append(current.Headers, Header{Name: name, Value: value})
+		}
+	}
+	return rules, findings
+}
+
+// writeHeaders writes the headers as server level add_header directives,
+// which locations with add_header directives of their own do not inherit.
+// Since add_header leaves out empty values, each header is added with a
+// variable that the rules whose path matches set, the last one winning.
+func writeHeaders(conf *strings.Builder, rules []HeaderRule) {
+	names := []string{}
+	vars := map[string]string{}
+	for _, rule := range rules {
+		for _, header := range rule.Headers {
+			key := strings.ToLower(header.Name)
+			if _, ok := vars[key]; !ok {
+				names = append(names, header.Name)
+				vars[key] = fmt.Sprintf("$netlify_header_%d", len(names))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	conf.WriteString("# Generated from _headers\n")
+	for _, name := range names {
+		fmt.Fprintf(conf, "set %s \"\";\n", vars[strings.ToLower(name)])
+	}
+	for _, rule := range rules {
+		if len(rule.Headers) == 0 {
+			continue
+		}
+		pattern, _, _ := pathPattern(rule.Path, false)
+		fmt.Fprintf(conf, "\n# %s:%d %s\nif ($uri ~ %s) {\n", HeadersFile, rule.Line, rule.Path, site.Quote(pattern))
+		for _, header := range rule.Headers {
+			fmt.Fprintf(conf, "  set %s %s;\n", vars[strings.ToLower(header.Name)], site.Quote(header.Value))
+		}
+		conf.WriteString("}\n")
+	}
+	conf.WriteString("\n")
+	for _, name := range names {
+		fmt.Fprintf(conf, "add_header %s %s always;\n", name, vars[strings.ToLower(name)])
+	}
+	conf.WriteString("\n")
+}
@@ -0,0 +1,139 @@
+// Package netlify translates the _redirects and _headers files that static
+// hosts such as Netlify read into nginx directives for a server block.
+package netlify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	RedirectsFile = "_redirects"
+	HeadersFile   = "_headers"
+)
+
+// Rules are the rules of a site's _redirects and _headers files that can be
+// written to nginx.conf, along with findings for the ones that cannot.
+type Rules struct {
+	Redirects []Redirect
+	Headers   []HeaderRule
+	Findings  []Finding
+}
+
+// Finding is a rule that was left out, located at a line of its file.
+type Finding struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s", f.File, f.Line, f.Msg)
+}
+
+// Read parses the _redirects and _headers files in dir. It returns nil if
+// dir has neither.
+func Read(dir string) (*Rules, error) {
+	redirects, err := readFile(filepath.Join(dir, RedirectsFile))
+	if err != nil {
+		return nil, err
+	}
+	headers, err := readFile(filepath.Join(dir, HeadersFile))
+	if err != nil {
+		return nil, err
+	}
+	if redirects == nil && headers == nil {
+		return nil, nil
+	}
+
+	rules := &Rules{}
+	var findings []Finding
+	rules.Headers, findings = ParseHeaders(HeadersFile, headers)
+	rules.Findings = append(rules.Findings, findings...)
+	rules.Redirects, findings = ParseRedirects(RedirectsFile, redirects)
+	rules.Findings = append(rules.Findings, findings...)
+	return rules, nil
+}
+
+func readFile(path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return contents, err
+}
+
+// Conf returns the directives for the rules, which belong in a server block.
+// Headers come first, so that they are also added to redirects.
+func (r *Rules) Conf() string {
+	conf := &strings.Builder{}
+	writeHeaders(conf, r.Headers)
+	writeRedirects(conf, r.Redirects)
+	return conf.String()
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`^:([A-Za-z_][A-Za-z0-9_]*)$`)
+	// unsafeChars cannot be written to nginx.conf without escapes that
+	// nginx does not have, such as $ in a string.
+	unsafeChars = " \t\"'{};$#\\"
+)
+
+// pathPattern returns the regex of $uri values that path matches. A * stands
+// for any text and a segment such as :year for one segment. With captures,
+// the segments are captured into $netlify_<name> and * into $netlify_splat,
+// which a * may then only end the path with.
+func pathPattern(path string, captures bool) (string, []string, error) {
+	pattern := &strings.Builder{}
+	pattern.WriteString("^")
+	names := []string{}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if i > 0 {
+			pattern.WriteString("/")
+		}
+
+		if match := placeholderPattern.FindStringSubmatch(segment); match != nil {
+			if !captures {
+				pattern.WriteString("[^/]+")
+				continue
+			}
+			for _, name := range names {
+				if name == match[1] {
+					return "", nil, fmt.Errorf("%s uses :%s twice", path, name)
+				}
+			}
+			names = append(names, match[1])
+			fmt.Fprintf(pattern, "(?<netlify_%s>[^/]+)", match[1])
+			continue
+		}
+
+		if captures && strings.Contains(segment, "*") {
+			if segment != "*" || i != len(segments)-1 {
+				return "", nil, fmt.Errorf("%s has a * that does not end it", path)
+			}
+			names = append(names, "splat")
+			pattern.WriteString("(?<netlify_splat>.*)")
+			continue
+		}
+
+		literals := strings.Split(segment, "*")
+		for j, literal := range literals {
+			if j > 0 {
+				pattern.WriteString(".*")
+			}
+			pattern.WriteString(regexp.QuoteMeta(literal))
+		}
+	}
+
+	// Like static hosts, a path matches with or without a trailing slash.
+	if last := segments[len(segments)-1]; last != "" && !strings.Contains(last, "*") {
+		pattern.WriteString("/?")
+	}
+	pattern.WriteString("$")
+	return pattern.String(), names, nil
+}
@@ -0,0 +1,13 @@
+package netlify_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNetlify(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Netlify Suite")
+}
@@ -0,0 +1,157 @@
+package netlify_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/netlify"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+	})
+
+	args := func(d *nginxconf.Directive) []string {
+		values := []string{}
+		for _, arg := range d.Args {
+			values = append(values, arg.Value)
+		}
+		return values
+	}
+
+	parse := func(rules *netlify.Rules) *nginxconf.Config {
+		conf, err := nginxconf.Parse("generated-includes.conf", []byte(rules.Conf()))
+		Expect(err).NotTo(HaveOccurred())
+		return conf
+	}
+
+	It("returns nil without _redirects and _headers", func() {
+		Expect(netlify.Read(dir)).To(BeNil())
+	})
+
+	Describe("_redirects", func() {
+		It("redirects with status codes, splats and placeholders", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "_redirects"), []byte(`# Old blog
+/news/*              /blog/:splat          301!
+/posts/:year/:month  /archive/:year-:month 302!
+/home                /
+`), 0644)).To(Succeed())
+
+			rules, err := netlify.Read(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules.Findings).To(BeEmpty())
+			Expect(rules.Redirects).To(Equal([]netlify.Redirect{
+				{Line: 2, From: "/news/*", To: "/blog/:splat", Status: 301, Force: true},
+				{Line: 3, From: "/posts/:year/:month", To: "/archive/:year-:month", Status: 302, Force: true},
+				{Line: 4, From: "/home", To: "/", Status: 301},
+			}))
+
+			conf := parse(rules)
+			ifs := conf.Find("if")
+			Expect(args(ifs[0])).To(Equal([]string{"($uri", "~", "^/news/(?<netlify_splat>.*)$", ")"}))
+			Expect(args(ifs[1])).To(Equal([]string{"($uri", "~", "^/posts/(?<netlify_year>[^/]+)/(?<netlify_month>[^/]+)/?$", ")"}))
+			returns := conf.Find("return")
+			Expect(args(returns[0])).To(Equal([]string{"301", "/blog/${netlify_splat}$is_args$args"}))
+			Expect(args(returns[1])).To(Equal([]string{"302", "/archive/${netlify_year}-${netlify_month}$is_args$args"}))
+			Expect(args(returns[2])).To(Equal([]string{"301", "/$is_args$args"}))
+		})
+
+		It("serves files that exist instead of the rules that are not forced", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "_redirects"), []byte("/*    /index.html   200\n/*    /404.html     404\n"), 0644)).To(Succeed())
+
+			rules, err := netlify.Read(dir)
+			Expect(err).NotTo(HaveOccurred())
+			conf := parse(rules)
+
+			ifs := conf.Find("if")
+			Expect(ifs).To(HaveLen(3))
+			Expect(args(ifs[1])).To(Equal([]string{"(-e", "$request_filename)"}))
+			Expect(args(ifs[2])).To(Equal([]string{"($netlify_rule", "=", "1)"}))
+			Expect(args(conf.Find("rewrite")[0])).To(Equal([]string{"^", "/index.html", "last"}))
+			Expect(args(conf.Find("error_page")[0])).To(Equal([]string{"404", "/404.html"}))
+		})
+
+		It("reports the rules that nginx cannot express with their line numbers", func() {
+			redirects, findings := netlify.ParseRedirects("_redirects", []byte(`/ok /fine
+https://old.example.com/* https://example.com/:splat 301!
+/store id=:id /blog/:id 301
+/api/* https://api.example.com/:splat 200
+/fr/* /fr/index.html 200 Language=fr
+/gone /nothing 410
+/a/* /b/:page 301
+/c/*/d /e 301
+`))
+			Expect(redirects).To(HaveLen(1))
+			Expect(findings).To(Equal([]netlify.Finding{
+				{File: "_redirects", Line: 2, Msg: "redirects from other domains, such as https://old.example.com/*, are not supported"},
+				{File: "_redirects", Line: 3, Msg: "matching query parameters, such as id=:id, is not supported"},
+				{File: "_redirects", Line: 4, Msg: "proxying to https://api.example.com/:splat is not supported, use nginx.site.proxies or proxy_pass instead"},
+				{File: "_redirects", Line: 5, Msg: "conditions, such as Language=fr, are not supported"},
+				{File: "_redirects", Line: 6, Msg: "status 410 is not supported"},
+				{File: "_redirects", Line: 7, Msg: "/b/:page uses :page, which /a/* does not capture"},
+				{File: "_redirects", Line: 8, Msg: "/c/*/d has a * that does not end it"},
+			}))
+			Expect(findings[0].String()).To(Equal("_redirects:2: redirects from other domains, such as https://old.example.com/*, are not supported"))
+		})
+	})
+
+	Describe("_headers", func() {
+		It("adds the headers of the paths that match", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "_headers"), []byte(`/*
+  X-Frame-Options: DENY
+  Content-Security-Policy: default-src 'self'; img-src "data:"
+
+/assets/*.js
+  Cache-Control: public, max-age=31536000
+  x-frame-options: SAMEORIGIN
+`), 0644)).To(Succeed())
+
+			rules, err := netlify.Read(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules.Findings).To(BeEmpty())
+			conf := parse(rules)
+
+			ifs := conf.Find("if")
+			Expect(args(ifs[0])).To(Equal([]string{"($uri", "~", "^/.*$", ")"}))
+			Expect(args(ifs[1])).To(Equal([]string{"($uri", "~", `^/assets/.*\.js$`, ")"}))
+
+			sets := conf.Find("set")
+			Expect(args(sets[3])).To(Equal([]string{"$netlify_header_1", "DENY"}))
+			Expect(args(sets[4])).To(Equal([]string{"$netlify_header_2", `default-src 'self'; img-src "data:"`}))
+			Expect(args(sets[6])).To(Equal([]string{"$netlify_header_1", "SAMEORIGIN"}))
+
+			headers := conf.Find("add_header")
+			Expect(headers).To(HaveLen(3))
+			Expect(args(headers[0])).To(Equal([]string{"X-Frame-Options", "$netlify_header_1", "always"}))
+			Expect(args(headers[2])).To(Equal([]string{"Cache-Control", "$netlify_header_3", "always"}))
+		})
+
+		It("reports the rules that nginx cannot express with their line numbers", func() {
+			rules, findings := netlify.ParseHeaders("_headers", []byte(`  X-Early: 1
+https://example.com/*
+  X-Other-Domain: 1
+/ok
+  Bad Header: 1
+  X-Price: $5
+  X-Fine: yes
+`))
+			Expect(rules).To(Equal([]netlify.HeaderRule{{Line: 4, Path: "/ok", Headers: []netlify.Header{{Name: "X-Fine", Value: "yes"}}}}))
+			Expect(findings).To(Equal([]netlify.Finding{
+				{File: "_headers", Line: 1, Msg: "expected a path before the header X-Early: 1"},
+				{File: "_headers", Line: 2, Msg: "headers for other domains, such as https://example.com/*, are not supported"},
+				{File: "_headers", Line: 5, Msg: "expected a header such as X-Frame-Options: DENY, not Bad Header: 1"},
+				{File: "_headers", Line: 6, Msg: "the value of header X-Price has a $, which nginx cannot send as it is"},
+			}))
+		})
+	})
+})
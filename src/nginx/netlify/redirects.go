@@ -0,0 +1,167 @@
+package netlify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
+)
+
+// Redirect is a rule of _redirects, which sends the requests for From to To
+// with Status: a redirect for 3xx, a rewrite for 200 and the page for
+// missing files for 404. Unless Force is set, files that exist are served as
+// they are.
+type Redirect struct {
+	Line   int
+	From   string
+	To     string
+	Status int
+	Force  bool
+}
+
+const defaultRedirectStatus = 301
+
+var (
+	statusPattern    = regexp.MustCompile(`^([0-9]{3})(!?)$`)
+	toPlaceholder    = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+	redirectStatuses = map[int]bool{301: true, 302: true, 303: true, 307: true, 308: true}
+)
+
+// ParseRedirects parses the _redirects file in contents, which file names in
+// findings.
+func ParseRedirects(file string, contents []byte) ([]Redirect, []Finding) {
+	redirects := []Redirect{}
+	findings := []Finding{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		redirect, err := parseRedirect(line, fields)
+		if err != nil {
+			findings = append(findings, Finding{File: file, Line: line, Msg: err.Error()})
+			continue
+		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects, findings
+}
+
+func parseRedirect(line int, fields []string) (Redirect, error) {
+	redirect := Redirect{Line: line, From: fields[0], Status: defaultRedirectStatus}
+
+	if !strings.HasPrefix(redirect.From, "/") {
+		return Redirect{}, fmt.Errorf("redirects from other domains, such as %s, are not supported", redirect.From)
+	}
+	if len(fields) < 2 {
+		return Redirect{}, fmt.Errorf("expected a path and where to send it")
+	}
+	if strings.Contains(fields[1], "=") && !strings.HasPrefix(fields[1], "/") && !isURL(fields[1]) {
+		return Redirect{}, fmt.Errorf("matching query parameters, such as %s, is not supported", fields[1])
+	}
+	redirect.To = fields[1]
+
+	rest := fields[2:]
+	if len(rest) > 0 {
+		if match := statusPattern.FindStringSubmatch(rest[0]); match != nil {
+			redirect.Status, _ = strconv.Atoi(match[1])
+			redirect.Force = match[2] == "!"
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 0 {
+		return Redirect{}, fmt.Errorf("conditions, such as %s, are not supported", rest[0])
+	}
+
+	for _, path := range []string{redirect.From, redirect.To} {
+		if strings.ContainsAny(path, unsafeChars) {
+			return Redirect{}, fmt.Errorf("%s has characters that cannot be written to nginx.conf", path)
+		}
+	}
+
+	switch {
+	case redirectStatuses[redirect.Status]:
+	case redirect.Status == 200:
+		if !strings.HasPrefix(redirect.To, "/") {
+			return Redirect{}, fmt.Errorf("proxying to %s is not supported, use nginx.site.proxies or proxy_pass instead", redirect.To)
+		}
+	case redirect.Status == 404:
+		if redirect.From != "/*" || !strings.HasPrefix(redirect.To, "/") || strings.Contains(redirect.To, ":") {
+			return Redirect{}, fmt.Errorf("status 404 is only supported for the page of missing files, as in /* /404.html 404")
+		}
+	default:
+		return Redirect{}, fmt.Errorf("status %d is not supported", redirect.Status)
+	}
+
+	_, names, err := pathPattern(redirect.From, true)
+	if err != nil {
+		return Redirect{}, err
+	}
+	for _, match := range toPlaceholder.FindAllStringSubmatch(redirect.To, -1) {
+		if !slices.Contains(names, match[1]) {
+			return Redirect{}, fmt.Errorf("%s uses :%s, which %s does not capture", redirect.To, match[1], redirect.From)
+		}
+	}
+
+	return redirect, nil
+}
+
+func isURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// target returns To with its placeholders replaced by the variables that
+// pathPattern captures them into.
+func (r Redirect) target() string {
+	return toPlaceholder.ReplaceAllString(r.To, "$${netlify_$1}")
+}
+
+// action returns the directive that sends a matching request on.
+func (r Redirect) action() string {
+	if r.Status == 200 {
+		return fmt.Sprintf("rewrite ^ %s last;", r.target())
+	}
+
+	target := r.target()
+	if !strings.Contains(target, "?") {
+		target += "$is_args$args"
+	}
+	return fmt.Sprintf("return %d %s;", r.Status, target)
+}
+
+// writeRedirects writes the redirects in the order of _redirects, since the
+// first rule that matches wins. A rule that is not forced first checks that
+// no file exists at the path, through $netlify_rule since nginx cannot
+// combine conditions.
+func writeRedirects(conf *strings.Builder, redirects []Redirect) {
+	if len(redirects) == 0 {
+		return
+	}
+
+	conf.WriteString("# Generated from _redirects\n")
+	conf.WriteString("set $netlify_rule \"\";\n")
+	for _, redirect := range redirects {
+		fmt.Fprintf(conf, "\n# %s:%d %s %s %d\n", RedirectsFile, redirect.Line, redirect.From, redirect.To, redirect.Status)
+		if redirect.Status == 404 {
+			fmt.Fprintf(conf, "error_page 404 %s;\n", redirect.To)
+			continue
+		}
+
+		pattern, _, _ := pathPattern(redirect.From, true)
+		if redirect.Force {
+			fmt.Fprintf(conf, "if ($uri ~ %s) {\n  %s\n}\n", site.Quote(pattern), redirect.action())
+			continue
+		}
+		fmt.Fprintf(conf, "if ($uri ~ %s) {\n  set $netlify_rule %d;\n}\n", site.Quote(pattern), redirect.Line)
+		conf.WriteString("if (-e $request_filename) {\n  set $netlify_rule \"\";\n}\n")
+		fmt.Fprintf(conf, "if ($netlify_rule = %d) {\n  %s\n}\n", redirect.Line, redirect.action())
+	}
+}
@@ -0,0 +1,122 @@
+package nginxconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position identifies a location in a config file. Line and Column are
+// 1-based; Column counts bytes.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Arg is a single directive argument.
+type Arg struct {
+	// Value is the argument with quotes removed and escape sequences
+	// resolved. Template actions are kept verbatim.
+	Value string
+	// Raw is the argument exactly as it appears in the source.
+	Raw string
+	// Quote is the quote character the argument was enclosed in, or 0.
+	Quote byte
+	Pos   Position
+}
+
+// IsTemplate reports whether the whole argument is a single template action,
+// such as {{port}}.
+func (a Arg) IsTemplate() bool {
+	return a.Quote == 0 && isTemplateAction(a.Raw)
+}
+
+// HasTemplate reports whether the argument contains a template action.
+func (a Arg) HasTemplate() bool {
+	return strings.Contains(a.Raw, "{{")
+}
+
+// Directive is a simple directive such as `listen 8080;` or a block
+// directive such as `server { ... }`.
+type Directive struct {
+	Name string
+	Args []Arg
+	// Block holds the directives of a block directive. It is nil for simple
+	// directives and non-nil, possibly empty, for block directives.
+	Block []*Directive
+	// RawBlock holds the unparsed body of blocks that contain code in another
+	// language, such as OpenResty's content_by_lua_block.
+	RawBlock string
+	// Template is set for a template action that stands on its own in
+	// statement position, such as {{module "ngx_stream_module"}}. Such
+	// actions are not terminated by a semicolon and Name holds the action.
+	Template bool
+	Pos      Position
+}
+
+// IsBlock reports whether the directive has a block.
+func (d *Directive) IsBlock() bool {
+	return d.Block != nil || d.RawBlock != ""
+}
+
+// Arg returns the value of the i-th argument or "" if there is none.
+func (d *Directive) Arg(i int) string {
+	if i < len(d.Args) {
+		return d.Args[i].Value
+	}
+	return ""
+}
+
+// Config is a parsed config file.
+type Config struct {
+	File       string
+	Directives []*Directive
+}
+
+// Walk calls fn for every directive in depth-first source order. parents
+// holds the enclosing block directives, outermost first. Returning false from
+// fn skips the block of that directive.
+func (c *Config) Walk(fn func(d *Directive, parents []*Directive) bool) {
+	walk(c.Directives, nil, fn)
+}
+
+func walk(directives []*Directive, parents []*Directive, fn func(*Directive, []*Directive) bool) {
+	for _, d := range directives {
+		if fn(d, parents) && d.Block != nil {
+			walk(d.Block, append(parents[:len(parents):len(parents)], d), fn)
+		}
+	}
+}
+
+// Find returns every directive with the given name at any depth, in source
+// order.
+func (c *Config) Find(name string) []*Directive {
+	found := []*Directive{}
+	c.Walk(func(d *Directive, _ []*Directive) bool {
+		if !d.Template && d.Name == name {
+			found = append(found, d)
+		}
+		return true
+	})
+	return found
+}
+
+// SyntaxError describes a problem found while parsing.
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Pos.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Pos.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.Pos.File, e.Pos.Line, e.Msg)
+}
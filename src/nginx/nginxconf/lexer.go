@@ -0,0 +1,299 @@
+package nginxconf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokSemicolon
+	tokBlockStart
+	tokBlockEnd
+	tokEOF
+)
+
+type token struct {
+	kind    tokenKind
+	arg     Arg
+	pos     Position
+	endLine int
+}
+
+// lexer splits a config file into tokens following the rules of
+// ngx_conf_read_token, extended to skip over Go template actions.
+type lexer struct {
+	file string
+	src  []byte
+	off  int
+	line int
+	col  int
+}
+
+func (l *lexer) eof() bool {
+	return l.off >= len(l.src)
+}
+
+func (l *lexer) peekByte(n int) byte {
+	if l.off+n < len(l.src) {
+		return l.src[l.off+n]
+	}
+	return 0
+}
+
+func (l *lexer) advance() byte {
+	c := l.src[l.off]
+	l.off++
+	if c == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return c
+}
+
+func (l *lexer) pos() Position {
+	return Position{File: l.file, Line: l.line, Column: l.col}
+}
+
+func (l *lexer) errorf(pos Position, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpaceAndComments()
+
+	pos := l.pos()
+	if l.eof() {
+		return token{kind: tokEOF, pos: pos, endLine: pos.Line}, nil
+	}
+
+	switch c := l.peekByte(0); {
+	case c == ';':
+		l.advance()
+		return token{kind: tokSemicolon, pos: pos, endLine: pos.Line}, nil
+	case c == '{' && l.peekByte(1) != '{':
+		l.advance()
+		return token{kind: tokBlockStart, pos: pos, endLine: pos.Line}, nil
+	case c == '}':
+		l.advance()
+		return token{kind: tokBlockEnd, pos: pos, endLine: pos.Line}, nil
+	case c == '"' || c == '\'':
+		return l.quoted(pos)
+	default:
+		return l.word(pos)
+	}
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for !l.eof() {
+		switch l.peekByte(0) {
+		case ' ', '\t', '\r', '\n':
+			l.advance()
+		case '#':
+			for !l.eof() && l.peekByte(0) != '\n' {
+				l.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) word(pos Position) (token, error) {
+	start := l.off
+	var value strings.Builder
+
+	for !l.eof() {
+		c := l.peekByte(0)
+		switch {
+		case c == '{' && l.peekByte(1) == '{':
+			action, err := l.templateAction()
+			if err != nil {
+				return token{}, err
+			}
+			value.WriteString(action)
+			continue
+		case c == '{' && l.off > start && l.src[l.off-1] == '$':
+			// ${name} variable syntax
+			for !l.eof() && l.peekByte(0) != '}' {
+				value.WriteByte(l.advance())
+			}
+			if l.eof() {
+				return token{}, l.errorf(pos, "unterminated variable")
+			}
+			value.WriteByte(l.advance())
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '{' || c == '}':
+			return l.wordToken(pos, start, value.String(), 0), nil
+		case c == '\\' && l.off+1 < len(l.src):
+			l.advance()
+			value.WriteString(unescape(l.advance()))
+			continue
+		}
+		value.WriteByte(l.advance())
+	}
+
+	return l.wordToken(pos, start, value.String(), 0), nil
+}
+
+func (l *lexer) quoted(pos Position) (token, error) {
+	start := l.off
+	quote := l.advance()
+	var value strings.Builder
+
+	for {
+		if l.eof() {
+			return token{}, l.errorf(pos, "unterminated string")
+		}
+
+		c := l.peekByte(0)
+		switch {
+		case c == '{' && l.peekByte(1) == '{':
+			action, err := l.templateAction()
+			if err != nil {
+				return token{}, err
+			}
+			value.WriteString(action)
+		case c == '\\' && l.off+1 < len(l.src):
+			l.advance()
+			value.WriteString(unescape(l.advance()))
+		case c == quote:
+			l.advance()
+			if !l.eof() {
+				switch next := l.peekByte(0); next {
+				case ' ', '\t', '\r', '\n', ';', '{', ')':
+				default:
+					return token{}, l.errorf(l.pos(), "unexpected %q after quoted string", next)
+				}
+			}
+			return l.wordToken(pos, start, value.String(), quote), nil
+		default:
+			value.WriteByte(l.advance())
+		}
+	}
+}
+
+func (l *lexer) wordToken(pos Position, start int, value string, quote byte) token {
+	return token{
+		kind:    tokWord,
+		arg:     Arg{Value: value, Raw: string(l.src[start:l.off]), Quote: quote, Pos: pos},
+		pos:     pos,
+		endLine: l.line,
+	}
+}
+
+// templateAction consumes a {{ ... }} action, skipping over the string and
+// character literals it may contain, and returns it verbatim.
+func (l *lexer) templateAction() (string, error) {
+	pos := l.pos()
+	start := l.off
+	l.advance()
+	l.advance()
+
+	var quote byte
+	for !l.eof() {
+		c := l.advance()
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && !l.eof() {
+				l.advance()
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '`' || c == '\'':
+			quote = c
+		case c == '}' && l.peekByte(0) == '}':
+			l.advance()
+			return string(l.src[start:l.off]), nil
+		}
+	}
+
+	return "", l.errorf(pos, "unterminated template action")
+}
+
+// rawBlock consumes the body of a block that holds Lua code, up to and
+// including the closing brace, and returns the body.
+func (l *lexer) rawBlock(pos Position) (string, error) {
+	start := l.off
+	depth := 1
+
+	for !l.eof() {
+		c := l.advance()
+		switch {
+		case c == '"' || c == '\'':
+			for !l.eof() {
+				s := l.advance()
+				if s == '\\' && !l.eof() {
+					l.advance()
+				} else if s == c || s == '\n' {
+					break
+				}
+			}
+		case c == '-' && l.peekByte(0) == '-':
+			for !l.eof() && l.peekByte(0) != '\n' {
+				l.advance()
+			}
+		case c == '[' && (l.peekByte(0) == '[' || l.peekByte(0) == '='):
+			l.skipLongBracket()
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return string(l.src[start : l.off-1]), nil
+			}
+		}
+	}
+
+	return "", l.errorf(pos, `unexpected end of file, expecting "}"`)
+}
+
+// skipLongBracket skips a Lua long string such as [[ ... ]] or [==[ ... ]==]
+// whose opening "[" has already been consumed.
+func (l *lexer) skipLongBracket() {
+	level := 0
+	for l.peekByte(level) == '=' {
+		level++
+	}
+	if l.peekByte(level) != '[' {
+		return
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	for i := 0; i <= level; i++ {
+		l.advance()
+	}
+	for !l.eof() {
+		if bytes.HasPrefix(l.src[l.off:], []byte(closing)) {
+			for range closing {
+				l.advance()
+			}
+			return
+		}
+		l.advance()
+	}
+}
+
+func unescape(c byte) string {
+	switch c {
+	case '"', '\'', '\\':
+		return string(c)
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	case 'n':
+		return "\n"
+	default:
+		return "\\" + string(c)
+	}
+}
+
+func isTemplateAction(str string) bool {
+	return strings.HasPrefix(str, "{{") && strings.HasSuffix(str, "}}") && strings.Index(str[2:], "{{") == -1
+}
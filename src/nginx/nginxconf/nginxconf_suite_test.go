@@ -0,0 +1,13 @@
+package nginxconf_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNginxconf(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Nginxconf Suite")
+}
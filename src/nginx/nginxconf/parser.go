@@ -0,0 +1,138 @@
+// Package nginxconf parses nginx configuration files, including the Go
+// template actions that varify expands, into a tree of directives.
+package nginxconf
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseFile reads and parses the config file at path.
+func ParseFile(path string) (*Config, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(path, src)
+}
+
+// Parse parses src, naming it file in positions and errors. Template actions
+// such as {{port}} or {{env "X"}} are treated as opaque text wherever they
+// appear. On a syntax error, Parse returns a *SyntaxError together with the
+// directives parsed up to that point.
+func Parse(file string, src []byte) (*Config, error) {
+	p := &parser{lex: &lexer{file: file, src: src, line: 1, col: 1}}
+
+	directives, err := p.parseBlock(nil)
+	return &Config{File: file, Directives: directives}, err
+}
+
+type parser struct {
+	lex    *lexer
+	peeked *token
+}
+
+func (p *parser) next() (token, error) {
+	if p.peeked != nil {
+		tok := *p.peeked
+		p.peeked = nil
+		return tok, nil
+	}
+	return p.lex.next()
+}
+
+func (p *parser) peek() (token, error) {
+	if p.peeked == nil {
+		tok, err := p.lex.next()
+		if err != nil {
+			return tok, err
+		}
+		p.peeked = &tok
+	}
+	return *p.peeked, nil
+}
+
+// parseBlock parses directives until the end of the enclosing block, or the
+// end of the file when parent is nil. It returns the directives parsed so far
+// even when it fails.
+func (p *parser) parseBlock(parent *Directive) ([]*Directive, error) {
+	directives := []*Directive{}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return directives, err
+		}
+
+		switch tok.kind {
+		case tokEOF:
+			if parent != nil {
+				return directives, p.errorf(tok.pos, "unexpected end of file, expecting \"}\" to close %q opened on line %d", parent.Name, parent.Pos.Line)
+			}
+			return directives, nil
+		case tokBlockEnd:
+			if parent == nil {
+				return directives, p.errorf(tok.pos, `unexpected "}"`)
+			}
+			return directives, nil
+		case tokSemicolon:
+			return directives, p.errorf(tok.pos, `unexpected ";"`)
+		case tokBlockStart:
+			return directives, p.errorf(tok.pos, `unexpected "{"`)
+		}
+
+		d, err := p.parseDirective(tok)
+		if d != nil {
+			directives = append(directives, d)
+		}
+		if err != nil {
+			return directives, err
+		}
+	}
+}
+
+func (p *parser) parseDirective(first token) (*Directive, error) {
+	d := &Directive{Name: first.arg.Value, Pos: first.pos}
+
+	if first.arg.IsTemplate() {
+		next, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if next.kind == tokEOF || next.kind == tokBlockEnd || next.pos.Line > first.endLine {
+			d.Name = first.arg.Raw
+			d.Template = true
+			return d, nil
+		}
+	}
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.kind {
+		case tokWord:
+			d.Args = append(d.Args, tok.arg)
+		case tokSemicolon:
+			return d, nil
+		case tokBlockStart:
+			if strings.HasSuffix(d.Name, "_by_lua_block") {
+				d.RawBlock, err = p.lex.rawBlock(tok.pos)
+				return d, err
+			}
+			d.Block, err = p.parseBlock(d)
+			return d, err
+		case tokBlockEnd:
+			return nil, p.errorf(tok.pos, `unexpected "}", expecting ";" after %q`, d.Name)
+		case tokEOF:
+			return nil, p.errorf(tok.pos, `unexpected end of file, expecting ";" or "}" after %q`, d.Name)
+		}
+	}
+}
+
+func (p *parser) errorf(pos Position, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
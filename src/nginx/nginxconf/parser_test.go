@@ -0,0 +1,178 @@
+package nginxconf_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse", func() {
+	parse := func(src string) *nginxconf.Config {
+		conf, err := nginxconf.Parse("nginx.conf", []byte(src))
+		Expect(err).NotTo(HaveOccurred())
+		return conf
+	}
+
+	names := func(directives []*nginxconf.Directive) []string {
+		result := []string{}
+		for _, d := range directives {
+			result = append(result, d.Name)
+		}
+		return result
+	}
+
+	values := func(d *nginxconf.Directive) []string {
+		result := []string{}
+		for _, a := range d.Args {
+			result = append(result, a.Value)
+		}
+		return result
+	}
+
+	It("parses simple and block directives with positions", func() {
+		conf := parse("daemon off;\nevents { worker_connections 1024; }\nhttp {\n  server {\n    listen 8080;\n  }\n}\n")
+
+		Expect(names(conf.Directives)).To(Equal([]string{"daemon", "events", "http"}))
+		Expect(values(conf.Directives[0])).To(Equal([]string{"off"}))
+		Expect(conf.Directives[0].IsBlock()).To(BeFalse())
+		Expect(conf.Directives[1].IsBlock()).To(BeTrue())
+
+		listen := conf.Find("listen")
+		Expect(listen).To(HaveLen(1))
+		Expect(listen[0].Pos).To(Equal(nginxconf.Position{File: "nginx.conf", Line: 5, Column: 5}))
+		Expect(listen[0].Args[0].Pos).To(Equal(nginxconf.Position{File: "nginx.conf", Line: 5, Column: 12}))
+	})
+
+	It("parses empty blocks", func() {
+		conf := parse("events {}")
+		Expect(conf.Directives[0].Block).NotTo(BeNil())
+		Expect(conf.Directives[0].Block).To(BeEmpty())
+	})
+
+	It("ignores comments", func() {
+		conf := parse("# access_log off;\naccess_log /dev/stdout; # access_log off;\n")
+		Expect(conf.Find("access_log")).To(HaveLen(1))
+		Expect(conf.Find("access_log")[0].Arg(0)).To(Equal("/dev/stdout"))
+	})
+
+	It("treats # inside quotes as text", func() {
+		conf := parse(`add_header X-Test "a # b";`)
+		Expect(conf.Directives[0].Arg(1)).To(Equal("a # b"))
+	})
+
+	It("unquotes quoted arguments and resolves escapes", func() {
+		conf := parse(`return 200 "say \"hi\"\n"; set $a 'it\'s';`)
+
+		Expect(conf.Directives[0].Args[1].Value).To(Equal("say \"hi\"\n"))
+		Expect(conf.Directives[0].Args[1].Raw).To(Equal(`"say \"hi\"\n"`))
+		Expect(conf.Directives[0].Args[1].Quote).To(Equal(byte('"')))
+		Expect(conf.Directives[1].Arg(1)).To(Equal("it's"))
+	})
+
+	It("keeps ${name} variables inside words", func() {
+		conf := parse("proxy_pass http://${host}:8080;")
+		Expect(conf.Directives[0].Arg(0)).To(Equal("http://${host}:8080"))
+	})
+
+	It("supports if conditions", func() {
+		conf := parse(`if ($override != "") { return 200; }`)
+		Expect(values(conf.Directives[0])).To(Equal([]string{"($override", "!=", "", ")"}))
+		Expect(names(conf.Directives[0].Block)).To(Equal([]string{"return"}))
+	})
+
+	Context("with template actions", func() {
+		It("keeps actions in arguments as opaque tokens", func() {
+			conf := parse(`listen {{port}}; proxy_pass http://localhost:{{env "BACKEND_PORT"}};`)
+
+			Expect(conf.Directives[0].Args[0].Value).To(Equal("{{port}}"))
+			Expect(conf.Directives[0].Args[0].IsTemplate()).To(BeTrue())
+			Expect(conf.Directives[1].Args[0].Value).To(Equal(`http://localhost:{{env "BACKEND_PORT"}}`))
+			Expect(conf.Directives[1].Args[0].IsTemplate()).To(BeFalse())
+			Expect(conf.Directives[1].Args[0].HasTemplate()).To(BeTrue())
+		})
+
+		It("keeps actions with quotes inside quoted arguments", func() {
+			conf := parse(`set $override "{{env "OVERRIDE"}}";`)
+			Expect(conf.Directives[0].Arg(1)).To(Equal(`{{env "OVERRIDE"}}`))
+		})
+
+		It("does not mistake braces inside actions for blocks", func() {
+			conf := parse(`return 200 {{printf "%s}" "x"}};`)
+			Expect(conf.Directives[0].Arg(1)).To(Equal(`{{printf "%s}" "x"}}`))
+		})
+
+		It("parses actions that stand on their own as template directives", func() {
+			conf := parse("{{module \"ngx_stream_module\"}}\nevents {}\nhttp {\n  {{if true}}\n  gzip on;\n  {{end}}\n}\n")
+
+			Expect(names(conf.Directives)).To(Equal([]string{`{{module "ngx_stream_module"}}`, "events", "http"}))
+			Expect(conf.Directives[0].Template).To(BeTrue())
+			Expect(names(conf.Directives[2].Block)).To(Equal([]string{"{{if true}}", "gzip", "{{end}}"}))
+			Expect(conf.Find("gzip")).To(HaveLen(1))
+		})
+
+		It("parses actions in directive position followed by arguments as directives", func() {
+			conf := parse(`{{env "DIRECTIVE"}} on;`)
+			Expect(conf.Directives[0].Template).To(BeFalse())
+			Expect(values(conf.Directives[0])).To(Equal([]string{"on"}))
+		})
+	})
+
+	It("keeps lua blocks unparsed", func() {
+		conf := parse("location / {\n  content_by_lua_block {\n    ngx.say(\"}\") -- }\n    local t = { a = [[}]] }\n  }\n  gzip on;\n}\n")
+
+		location := conf.Directives[0]
+		Expect(names(location.Block)).To(Equal([]string{"content_by_lua_block", "gzip"}))
+		Expect(location.Block[0].RawBlock).To(ContainSubstring(`ngx.say("}")`))
+		Expect(location.Block[0].RawBlock).To(ContainSubstring(`local t = { a = [[}]] }`))
+	})
+
+	It("walks directives with their parents", func() {
+		conf := parse("http { server { location / { return 200; } } }")
+
+		var parents []string
+		conf.Walk(func(d *nginxconf.Directive, p []*nginxconf.Directive) bool {
+			if d.Name == "return" {
+				parents = names(p)
+			}
+			return true
+		})
+		Expect(parents).To(Equal([]string{"http", "server", "location"}))
+	})
+
+	DescribeTable("syntax errors",
+		func(src, message string) {
+			_, err := nginxconf.Parse("nginx.conf", []byte(src))
+			Expect(err).To(MatchError(message))
+		},
+		Entry("missing semicolon", "daemon off\n", `nginx.conf:2: unexpected end of file, expecting ";" or "}" after "daemon"`),
+		Entry("unclosed block", "http {\n  gzip on;\n", `nginx.conf:3: unexpected end of file, expecting "}" to close "http" opened on line 1`),
+		Entry("extra closing brace", "gzip on;\n}\n", `nginx.conf:2: unexpected "}"`),
+		Entry("stray semicolon", "gzip on;;", `nginx.conf:1: unexpected ";"`),
+		Entry("unterminated string", "return 200 \"hi;\n", `nginx.conf:1: unterminated string`),
+		Entry("unterminated action", "listen {{port;\n", `nginx.conf:1: unterminated template action`),
+		Entry("text after quoted string", `return 200 "a"b;`, `nginx.conf:1: unexpected 'b' after quoted string`),
+	)
+
+	It("returns the directives parsed before a syntax error", func() {
+		conf, err := nginxconf.Parse("nginx.conf", []byte("include a.conf;\nhttp {\n  include b.conf;\n  oops\n}"))
+		Expect(err).To(HaveOccurred())
+		Expect(conf.Find("include")).To(HaveLen(2))
+	})
+
+	It("parses files", func() {
+		dir, err := os.MkdirTemp("", "nginxconf")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+		path := filepath.Join(dir, "nginx.conf")
+		Expect(os.WriteFile(path, []byte("daemon off;"), 0644)).To(Succeed())
+
+		conf, err := nginxconf.ParseFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.File).To(Equal(path))
+		Expect(conf.Directives[0].Pos.File).To(Equal(path))
+	})
+})
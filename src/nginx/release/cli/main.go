@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/release"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func main() {
+	logger := libbuildpack.NewLogger(os.Stderr)
+
+	if len(os.Args) < 2 {
+		logger.Error("Usage: release <build-dir>")
+		os.Exit(1)
+	}
+
+	if err := release.Run(os.Args[1], os.Stdout); err != nil {
+		logger.Error("Unable to release: %s", err.Error())
+		os.Exit(1)
+	}
+}
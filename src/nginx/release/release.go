@@ -0,0 +1,92 @@
+package release
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Process is a process type in nginx.processes in buildpack.yml. The
+// launcher runs a separate nginx for it with the template at Conf, such as a
+// stream-only config for a worker.
+type Process struct {
+	Conf string `yaml:"conf"`
+}
+
+type BuildpackYML struct {
+	Nginx struct {
+		ConfigPath string             `yaml:"config_path"`
+		Processes  map[string]Process `yaml:"processes"`
+	} `yaml:"nginx"`
+}
+
+// ProcessTypes returns the commands of the process types of the app in
+// buildDir: web, which serves nginx.conf or nginx.config_path, and the ones
+// in nginx.processes. An entry for web in nginx.processes changes the
+// template that web serves.
+func ProcessTypes(buildDir string) (map[string]string, error) {
+	var bpYML BuildpackYML
+	bpYMLPath := filepath.Join(buildDir, "buildpack.yml")
+	if exists, err := libbuildpack.FileExists(bpYMLPath); err != nil {
+		return nil, err
+	} else if exists {
+		if err := libbuildpack.NewYAML().Load(bpYMLPath, &bpYML); err != nil {
+			return nil, err
+		}
+	}
+
+	confPath := bpYML.Nginx.ConfigPath
+	if confPath == "" {
+		confPath = "nginx.conf"
+	}
+
+	processTypes := map[string]string{"web": webCommand(confPath)}
+	for name, process := range bpYML.Nginx.Processes {
+		if process.Conf == "" {
+			return nil, fmt.Errorf("nginx.processes.%s needs a conf", name)
+		}
+		if filepath.IsAbs(process.Conf) {
+			return nil, fmt.Errorf("nginx.processes.%s.conf %q must be relative to the app", name, process.Conf)
+		}
+		if name == "web" {
+			processTypes[name] = webCommand(process.Conf)
+		} else {
+			processTypes[name] = command(process.Conf)
+		}
+	}
+	return processTypes, nil
+}
+
+// webCommand returns the command of the web process, which also retries the
+// hosts that did not resolve while staging.
+func webCommand(confPath string) string {
+	return command(confPath) + " -deferred-hosts $DEP_DIR/nginx/deferred-hosts.json"
+}
+
+func command(confPath string) string {
+	return fmt.Sprintf("launcher -buildpack-yml-path ./buildpack.yml -conf %s -local-modules $HOME/modules -global-modules $DEP_DIR/nginx/modules -generated-includes $DEP_DIR/nginx/generated-includes.conf",
+		"./"+filepath.ToSlash(filepath.Clean(confPath)))
+}
+
+// Run writes the release of the app in buildDir to out.
+func Run(buildDir string, out io.Writer) error {
+	processTypes, err := ProcessTypes(buildDir)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range processTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "---\ndefault_process_types:")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s: %s\n", name, processTypes[name])
+	}
+	return nil
+}
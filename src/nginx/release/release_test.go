@@ -0,0 +1,60 @@
+package release_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/release"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Release", func() {
+	var buildDir string
+
+	BeforeEach(func() {
+		buildDir = GinkgoT().TempDir()
+	})
+
+	It("runs nginx.conf with the launcher", func() {
+		out := &bytes.Buffer{}
+		Expect(release.Run(buildDir, out)).To(Succeed())
+		Expect(out.String()).To(Equal("---\ndefault_process_types:\n  web: launcher -buildpack-yml-path ./buildpack.yml -conf ./nginx.conf -local-modules $HOME/modules -global-modules $DEP_DIR/nginx/modules -generated-includes $DEP_DIR/nginx/generated-includes.conf -deferred-hosts $DEP_DIR/nginx/deferred-hosts.json\n"))
+	})
+
+	It("adds the process types in buildpack.yml", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  processes:\n    worker:\n      conf: stream/nginx.conf\n"), 0644)).To(Succeed())
+
+		processTypes, err := release.ProcessTypes(buildDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(processTypes).To(HaveKeyWithValue("web", ContainSubstring("-conf ./nginx.conf ")))
+		Expect(processTypes).To(HaveKeyWithValue("worker", "launcher -buildpack-yml-path ./buildpack.yml -conf ./stream/nginx.conf -local-modules $HOME/modules -global-modules $DEP_DIR/nginx/modules -generated-includes $DEP_DIR/nginx/generated-includes.conf"))
+	})
+
+	It("lets buildpack.yml change the template that web serves", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  processes:\n    web:\n      conf: web.conf\n"), 0644)).To(Succeed())
+
+		processTypes, err := release.ProcessTypes(buildDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(processTypes).To(HaveLen(1))
+		Expect(processTypes["web"]).To(ContainSubstring("-conf ./web.conf "))
+		Expect(processTypes["web"]).To(HaveSuffix(" -deferred-hosts $DEP_DIR/nginx/deferred-hosts.json"))
+	})
+
+	It("serves the config at nginx.config_path", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  config_path: deploy/nginx/nginx.conf\n"), 0644)).To(Succeed())
+
+		processTypes, err := release.ProcessTypes(buildDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(processTypes["web"]).To(ContainSubstring("-conf ./deploy/nginx/nginx.conf "))
+	})
+
+	It("fails on a process type without a conf", func() {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  processes:\n    worker: {}\n"), 0644)).To(Succeed())
+
+		_, err := release.ProcessTypes(buildDir)
+		Expect(err).To(MatchError("nginx.processes.worker needs a conf"))
+	})
+})
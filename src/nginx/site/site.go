@@ -0,0 +1,271 @@
+package site
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Config is nginx.site in buildpack.yml, from which nginx.conf is generated
+// for apps that do not have one.
+type Config struct {
+	// Root is the dir, relative to the app, that files are served from.
+	Root string `yaml:"root"`
+	// SPA serves index.html for paths that match no file, so that a single
+	// page app can route them.
+	SPA bool `yaml:"spa"`
+	// Gzip compresses text responses.
+	Gzip bool `yaml:"gzip"`
+	// ForceHTTPS redirects requests that reached the platform over plain
+	// HTTP to HTTPS.
+	ForceHTTPS bool              `yaml:"force_https"`
+	Headers    map[string]string `yaml:"headers"`
+	Proxies    []Proxy           `yaml:"proxies"`
+	// DirectoryListing lists the files of dirs without an index.
+	DirectoryListing bool `yaml:"directory_listing"`
+	// SSI turns on server side includes.
+	SSI bool `yaml:"ssi"`
+	// LocationInclude is included in the location that serves Root, for
+	// directives that the other settings do not cover.
+	LocationInclude string `yaml:"location_include"`
+	// BasicAuthFile is an htpasswd file, relative to the config, with the
+	// users that may see the site.
+	BasicAuthFile string `yaml:"basic_auth_file"`
+	// HideDotFiles answers requests for dot files with 404, as do the
+	// paths in Deny. A path in Deny that ends in / covers the dir.
+	HideDotFiles bool     `yaml:"hide_dot_files"`
+	Deny         []string `yaml:"deny"`
+}
+
+// Proxy passes the requests under Path to the URL To.
+type Proxy struct {
+	Path string `yaml:"path"`
+	To   string `yaml:"to"`
+}
+
+const defaultRoot = "public"
+
+var (
+	headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+.^_|~-]+$`)
+	proxyToPattern    = regexp.MustCompile(`^https?://[^\s;{}"']+$`)
+)
+
+// Validate checks c for values that cannot be written to nginx.conf.
+func (c Config) Validate() error {
+	if c.Root != "" {
+		if root := filepath.Clean(c.Root); filepath.IsAbs(root) || strings.HasPrefix(root, "..") {
+			return fmt.Errorf("nginx.site.root %q must be inside the app", c.Root)
+		}
+		if !isPlain(c.Root) {
+			return fmt.Errorf("invalid nginx.site.root %q", c.Root)
+		}
+	}
+
+	for name, value := range c.Headers {
+		if !ValidHeaderName(name) {
+			return fmt.Errorf("invalid header name %q in nginx.site.headers", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("the value of header %s in nginx.site.headers spans lines", name)
+		}
+	}
+
+	for name, value := range map[string]string{"location_include": c.LocationInclude, "basic_auth_file": c.BasicAuthFile} {
+		if value != "" && !isPlain(value) {
+			return fmt.Errorf("invalid nginx.site.%s %q", name, value)
+		}
+	}
+
+	for i, path := range c.Deny {
+		if !strings.HasPrefix(path, "/") || !isPlain(path) {
+			return fmt.Errorf("invalid nginx.site.deny[%d] %q, expected a path such as /secret.txt", i, path)
+		}
+	}
+
+	for i, proxy := range c.Proxies {
+		if !strings.HasPrefix(proxy.Path, "/") || !isPlain(proxy.Path) {
+			return fmt.Errorf("invalid nginx.site.proxies[%d].path %q, expected a path such as /api/", i, proxy.Path)
+		}
+		if !proxyToPattern.MatchString(proxy.To) {
+			return fmt.Errorf("invalid nginx.site.proxies[%d].to %q, expected an http or https URL", i, proxy.To)
+		}
+	}
+	return nil
+}
+
+func isPlain(value string) bool {
+	return value != "" && !strings.ContainsAny(value, " \t\r\n;{}\"'$")
+}
+
+// RootDir returns Root, or the dir that is served when it is not set.
+func (c Config) RootDir() string {
+	if c.Root == "" {
+		return defaultRoot
+	}
+	return c.Root
+}
+
+// Generate returns nginx.conf for c, as a template that listens on {{port}}.
+// The server pulls in {{generated_includes}}, the rules of the site's
+// _redirects and _headers files. Values from c are written so that varify
+// leaves them as they are.
+func Generate(c Config) string {
+	root := c.RootDir()
+
+	conf := &strings.Builder{}
+	conf.WriteString(`worker_processes 1;
+daemon off;
+
+error_log stderr;
+events { worker_connections 1024; }
+
+http {
+  charset utf-8;
+  log_format cloudfoundry 'NginxLog "$request" $status $body_bytes_sent';
+  access_log /dev/stdout cloudfoundry;
+  default_type application/octet-stream;
+` + mimeTypes + `
+  sendfile on;
+
+  tcp_nopush on;
+  keepalive_timeout 30;
+  port_in_redirect off; # Ensure that redirects don't include the internal container PORT - 8080
+`)
+
+	if c.Gzip {
+		conf.WriteString(`
+  gzip on;
+  gzip_proxied any;
+  gzip_vary on;
+  gzip_types text/plain text/css text/xml application/json application/javascript application/xml image/svg+xml;
+`)
+	}
+
+	fmt.Fprintf(conf, `
+  server {
+    listen {{port}};
+    root %s;
+    index index.html index.htm;
+
+    {{generated_includes}}
+`, root)
+
+	if c.DirectoryListing {
+		conf.WriteString("    autoindex on;\n")
+	}
+	if c.SSI {
+		conf.WriteString("    ssi on;\n")
+	}
+	if c.BasicAuthFile != "" {
+		fmt.Fprintf(conf, "    auth_basic \"Restricted\";\n    auth_basic_user_file %s;\n", c.BasicAuthFile)
+	}
+
+	if len(c.Headers) > 0 {
+		conf.WriteString("\n")
+		names := []string{}
+		for name := range c.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(conf, "    add_header %s %s always;\n", name, templateText(Quote(c.Headers[name])))
+		}
+	}
+
+	if c.ForceHTTPS {
+		conf.WriteString(`
+    if ($http_x_forwarded_proto != "https") {
+      return 301 https://$host$request_uri;
+    }
+`)
+	}
+
+	if c.SPA || c.LocationInclude != "" {
+		conf.WriteString("\n    location / {\n")
+		if c.SPA {
+			conf.WriteString("      try_files $uri $uri/ /index.html;\n")
+		}
+		if c.LocationInclude != "" {
+			fmt.Fprintf(conf, "      include %s;\n", c.LocationInclude)
+		}
+		conf.WriteString("    }\n")
+	}
+
+	if c.HideDotFiles {
+		conf.WriteString(`
+    location ~ /\. {
+      return 404;
+    }
+`)
+	}
+	for _, path := range c.Deny {
+		modifier := "="
+		if strings.HasSuffix(path, "/") {
+			modifier = "^~"
+		}
+		fmt.Fprintf(conf, "\n    location %s %s {\n      return 404;\n    }\n", modifier, path)
+	}
+
+	for _, proxy := range c.Proxies {
+		fmt.Fprintf(conf, `
+    location %s {
+      proxy_pass %s;
+      proxy_http_version 1.1;
+      proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+      proxy_set_header X-Forwarded-Proto $http_x_forwarded_proto;
+    }
+`, proxy.Path, proxy.To)
+	}
+
+	conf.WriteString("  }\n}\n")
+	return conf.String()
+}
+
+// ValidHeaderName reports whether name is an HTTP header name, which
+// add_header can write as is.
+func ValidHeaderName(name string) bool {
+	return headerNamePattern.MatchString(name)
+}
+
+// Quote returns value as a double quoted nginx string.
+func Quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// templateText escapes the template actions in value, so that {{ reaches
+// nginx.conf as is. varify renders the config in two phases, so the action
+// that writes {{ is itself written by an action.
+func templateText(value string) string {
+	return strings.ReplaceAll(value, "{{", `{{"{{\"{{\"}}"}}`)
+}
+
+// mimeTypes covers the files that static sites serve, since a generated
+// config cannot count on the app having mime.types.
+const mimeTypes = `  types {
+    text/html html htm;
+    text/css css;
+    text/plain txt;
+    text/xml xml;
+    application/javascript js mjs;
+    application/json json map;
+    application/manifest+json webmanifest;
+    application/wasm wasm;
+    application/pdf pdf;
+    application/zip zip;
+    image/svg+xml svg svgz;
+    image/png png;
+    image/jpeg jpeg jpg;
+    image/gif gif;
+    image/webp webp;
+    image/avif avif;
+    image/x-icon ico;
+    font/woff woff;
+    font/woff2 woff2;
+    font/ttf ttf;
+    font/otf otf;
+    audio/mpeg mp3;
+    video/mp4 mp4;
+    video/webm webm;
+  }`
@@ -0,0 +1,112 @@
+package site_test
+
+import (
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generate", func() {
+	parse := func(c site.Config) *nginxconf.Config {
+		conf, err := nginxconf.Parse("nginx.conf", []byte(site.Generate(c)))
+		Expect(err).NotTo(HaveOccurred())
+		return conf
+	}
+
+	args := func(d *nginxconf.Directive) []string {
+		values := []string{}
+		for _, arg := range d.Args {
+			values = append(values, arg.Value)
+		}
+		return values
+	}
+
+	It("serves public on {{port}} by default", func() {
+		conf := parse(site.Config{})
+		Expect(args(conf.Find("listen")[0])).To(Equal([]string{"{{port}}"}))
+		Expect(args(conf.Find("root")[0])).To(Equal([]string{"public"}))
+		Expect(args(conf.Find("daemon")[0])).To(Equal([]string{"off"}))
+		Expect(conf.Find("gzip")).To(BeEmpty())
+		Expect(conf.Find("location")).To(BeEmpty())
+		Expect(conf.Find("if")).To(BeEmpty())
+		Expect(site.Generate(site.Config{})).To(ContainSubstring("    {{generated_includes}}\n"))
+	})
+
+	It("generates the features that are turned on", func() {
+		conf := parse(site.Config{
+			Root:       "dist",
+			SPA:        true,
+			Gzip:       true,
+			ForceHTTPS: true,
+			Headers:    map[string]string{"X-Frame-Options": "DENY", "Content-Security-Policy": `default-src 'self'; script-src "x"`},
+			Proxies:    []site.Proxy{{Path: "/api/", To: "http://backend.apps.internal:8080"}},
+		})
+
+		Expect(args(conf.Find("root")[0])).To(Equal([]string{"dist"}))
+		Expect(args(conf.Find("gzip")[0])).To(Equal([]string{"on"}))
+		Expect(args(conf.Find("try_files")[0])).To(Equal([]string{"$uri", "$uri/", "/index.html"}))
+		Expect(args(conf.Find("return")[0])).To(Equal([]string{"301", "https://$host$request_uri"}))
+
+		headers := conf.Find("add_header")
+		Expect(headers).To(HaveLen(2))
+		Expect(args(headers[0])).To(Equal([]string{"Content-Security-Policy", `default-src 'self'; script-src "x"`, "always"}))
+		Expect(args(headers[1])).To(Equal([]string{"X-Frame-Options", "DENY", "always"}))
+
+		locations := conf.Find("location")
+		Expect(locations).To(HaveLen(2))
+		Expect(args(locations[1])).To(Equal([]string{"/api/"}))
+		Expect(args(conf.Find("proxy_pass")[0])).To(Equal([]string{"http://backend.apps.internal:8080"}))
+	})
+
+	It("generates the settings that Staticfile apps use", func() {
+		conf := parse(site.Config{
+			Root:             ".",
+			DirectoryListing: true,
+			SSI:              true,
+			LocationInclude:  "nginx/conf/includes/*.conf",
+			BasicAuthFile:    "Staticfile.auth",
+			HideDotFiles:     true,
+			Deny:             []string{"/Staticfile", "/nginx/"},
+		})
+
+		Expect(args(conf.Find("autoindex")[0])).To(Equal([]string{"on"}))
+		Expect(args(conf.Find("ssi")[0])).To(Equal([]string{"on"}))
+		Expect(args(conf.Find("auth_basic_user_file")[0])).To(Equal([]string{"Staticfile.auth"}))
+		Expect(args(conf.Find("include")[0])).To(Equal([]string{"nginx/conf/includes/*.conf"}))
+		Expect(conf.Find("try_files")).To(BeEmpty())
+
+		locations := conf.Find("location")
+		Expect(locations).To(HaveLen(4))
+		Expect(args(locations[0])).To(Equal([]string{"/"}))
+		Expect(args(locations[1])).To(Equal([]string{"~", `/\.`}))
+		Expect(args(locations[2])).To(Equal([]string{"=", "/Staticfile"}))
+		Expect(args(locations[3])).To(Equal([]string{"^~", "/nginx/"}))
+	})
+})
+
+var _ = Describe("Validate", func() {
+	It("accepts a site", func() {
+		Expect(site.Config{Root: "dist/app", Headers: map[string]string{"X-Frame-Options": "DENY"}, Proxies: []site.Proxy{{Path: "/api/", To: "https://example.com"}}}.Validate()).To(Succeed())
+	})
+
+	It("rejects a root outside the app", func() {
+		Expect(site.Config{Root: "../dist"}.Validate()).To(MatchError(`nginx.site.root "../dist" must be inside the app`))
+	})
+
+	It("rejects invalid headers", func() {
+		Expect(site.Config{Headers: map[string]string{"X Frame": "DENY"}}.Validate()).To(MatchError(`invalid header name "X Frame" in nginx.site.headers`))
+		Expect(site.Config{Headers: map[string]string{"X-Frame-Options": "DENY\nX-Other: 1"}}.Validate()).To(MatchError("the value of header X-Frame-Options in nginx.site.headers spans lines"))
+	})
+
+	It("rejects invalid includes and denied paths", func() {
+		Expect(site.Config{LocationInclude: "a.conf; root /"}.Validate()).To(MatchError(`invalid nginx.site.location_include "a.conf; root /"`))
+		Expect(site.Config{Deny: []string{"secret"}}.Validate()).To(MatchError(`invalid nginx.site.deny[0] "secret", expected a path such as /secret.txt`))
+	})
+
+	It("rejects invalid proxies", func() {
+		Expect(site.Config{Proxies: []site.Proxy{{Path: "api", To: "http://example.com"}}}.Validate()).To(MatchError(`invalid nginx.site.proxies[0].path "api", expected a path such as /api/`))
+		Expect(site.Config{Proxies: []site.Proxy{{Path: "/api/", To: "example.com; root /"}}}.Validate()).To(MatchError(`invalid nginx.site.proxies[0].to "example.com; root /", expected an http or https URL`))
+	})
+})
@@ -0,0 +1,167 @@
+package site
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"gopkg.in/yaml.v2"
+)
+
+// Staticfile is the Staticfile of an app that the staticfile buildpack used
+// to stage, translated into the Config it corresponds to.
+type Staticfile struct {
+	Config Config
+	// Notes explain the settings that could not be translated, for apps to
+	// migrate by hand.
+	Notes []string
+	// Defaults explain the choices made for settings that the Staticfile
+	// leaves out.
+	Defaults []string
+}
+
+const (
+	staticfileName     = "Staticfile"
+	staticfileAuthName = "Staticfile.auth"
+	// staticfileIncludeDir is where the staticfile buildpack looked for the
+	// files that location_include names.
+	staticfileIncludeDir = "nginx/conf"
+	hstsMaxAge           = "max-age=31536000"
+)
+
+// staticfileSkipped are the files and dirs of an app that the staticfile
+// buildpack did not serve when it served the app dir itself, along with the
+// ones that stage the app with nginx-buildpack.
+var staticfileSkipped = []string{
+	"/" + staticfileName, "/" + staticfileAuthName, "/manifest.yml", "/stackato.yml", "/.profile",
+	"/buildpack.yml", "/nginx.conf", "/.cloudfoundry/", "/nginx/",
+}
+
+// staticfileNotes covers the settings of the staticfile buildpack that have
+// no counterpart in Config.
+var staticfileNotes = map[string]string{
+	"status_codes": "status_codes is not supported, add error_page directives to an nginx.conf of your own instead",
+	"enable_http2": "enable_http2 is not supported, since the platform terminates HTTP/2 before nginx",
+	"gzip":         "gzip is not a Staticfile setting, set nginx.site.gzip in buildpack.yml instead",
+}
+
+// ReadStaticfile reads the Staticfile in appDir, along with Staticfile.auth.
+// It returns nil if the app has no Staticfile.
+func ReadStaticfile(appDir string) (*Staticfile, error) {
+	contents, err := os.ReadFile(filepath.Join(appDir, staticfileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	settings := yaml.MapSlice{}
+	if err := yaml.Unmarshal(contents, &settings); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", staticfileName, err)
+	}
+
+	sf := &Staticfile{Config: Config{HideDotFiles: true, Headers: map[string]string{}}}
+	hsts := []string{}
+	for _, setting := range settings {
+		key := fmt.Sprint(setting.Key)
+		value := strings.TrimSpace(fmt.Sprint(setting.Value))
+		if setting.Value == nil {
+			value = ""
+		}
+
+		switch key {
+		case "root":
+			sf.Config.Root = value
+		case "pushstate":
+			sf.Config.SPA = isEnabled(value)
+		case "force_https":
+			sf.Config.ForceHTTPS = isEnabled(value)
+		case "directory":
+			sf.Config.DirectoryListing = value == "visible"
+		case "ssi":
+			sf.Config.SSI = isEnabled(value)
+		case "host_dot_files":
+			sf.Config.HideDotFiles = !isEnabled(value)
+		case "location_include":
+			if value != "" {
+				sf.Config.LocationInclude = path.Join(staticfileIncludeDir, value)
+			}
+		case "http_strict_transport_security":
+			if isEnabled(value) {
+				hsts = append([]string{hstsMaxAge}, hsts...)
+			}
+		case "http_strict_transport_security_include_subdomains":
+			if isEnabled(value) {
+				hsts = append(hsts, "includeSubDomains")
+			}
+		case "http_strict_transport_security_preload":
+			if isEnabled(value) {
+				hsts = append(hsts, "preload")
+			}
+		default:
+			note, ok := staticfileNotes[key]
+			if !ok {
+				note = fmt.Sprintf("%s is not a setting that nginx-buildpack knows, so it is ignored", key)
+			}
+			sf.Notes = append(sf.Notes, note)
+		}
+	}
+
+	if len(hsts) > 0 {
+		if hsts[0] != hstsMaxAge {
+			sf.Notes = append(sf.Notes, "http_strict_transport_security_include_subdomains and http_strict_transport_security_preload are ignored without http_strict_transport_security")
+		} else {
+			sf.Config.Headers["Strict-Transport-Security"] = strings.Join(hsts, "; ")
+		}
+	}
+
+	if exists, err := libbuildpack.FileExists(filepath.Join(appDir, staticfileAuthName)); err != nil {
+		return nil, err
+	} else if exists {
+		sf.Config.BasicAuthFile = staticfileAuthName
+	}
+
+	if sf.Config.Root == "" {
+		root, err := staticfileDefaultRoot(appDir)
+		if err != nil {
+			return nil, err
+		}
+		sf.Config.Root = root
+		if root == defaultRoot {
+			sf.Defaults = append(sf.Defaults, "root is not set, so public is served, as the staticfile buildpack serves it")
+		} else {
+			sf.Defaults = append(sf.Defaults, fmt.Sprintf("root is not set and there is no public dir, so the app dir is served without %s", strings.Join(staticfileSkipped, ", ")))
+		}
+	}
+
+	// With the app itself as the root, the files that stage it would be
+	// served along with the site.
+	if filepath.Clean(sf.Config.Root) == "." {
+		sf.Config.Deny = append([]string{}, staticfileSkipped...)
+	}
+
+	return sf, nil
+}
+
+// staticfileDefaultRoot returns the dir that the staticfile buildpack served
+// when the Staticfile has no root: public if the app has one, or else the app
+// dir itself.
+func staticfileDefaultRoot(appDir string) (string, error) {
+	info, err := os.Stat(filepath.Join(appDir, defaultRoot))
+	if os.IsNotExist(err) {
+		return ".", nil
+	} else if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return ".", nil
+	}
+	return defaultRoot, nil
+}
+
+func isEnabled(value string) bool {
+	return value == "enabled" || value == "true"
+}
@@ -0,0 +1,102 @@
+package site_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReadStaticfile", func() {
+	var appDir string
+
+	BeforeEach(func() {
+		var err error
+		appDir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, appDir)
+	})
+
+	writeStaticfile := func(contents string) {
+		Expect(os.WriteFile(filepath.Join(appDir, "Staticfile"), []byte(contents), 0644)).To(Succeed())
+	}
+
+	It("returns nil without a Staticfile", func() {
+		Expect(site.ReadStaticfile(appDir)).To(BeNil())
+	})
+
+	It("serves the app itself and hides the files that stage it without a public dir", func() {
+		writeStaticfile("")
+		staticfile, err := site.ReadStaticfile(appDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(staticfile.Config.Root).To(Equal("."))
+		Expect(staticfile.Config.HideDotFiles).To(BeTrue())
+		Expect(staticfile.Config.Deny).To(ContainElements("/Staticfile", "/Staticfile.auth", "/nginx.conf", "/manifest.yml", "/nginx/"))
+		Expect(staticfile.Config.Validate()).To(Succeed())
+		Expect(staticfile.Defaults).To(ConsistOf(HavePrefix("root is not set and there is no public dir, so the app dir is served without /Staticfile, ")))
+		Expect(staticfile.Notes).To(BeEmpty())
+	})
+
+	It("serves public when the app has one, as the staticfile buildpack does", func() {
+		writeStaticfile("pushstate: enabled\n")
+		Expect(os.Mkdir(filepath.Join(appDir, "public"), 0755)).To(Succeed())
+		staticfile, err := site.ReadStaticfile(appDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(staticfile.Config.Root).To(Equal("public"))
+		Expect(staticfile.Config.Deny).To(BeEmpty())
+		Expect(staticfile.Defaults).To(Equal([]string{"root is not set, so public is served, as the staticfile buildpack serves it"}))
+	})
+
+	It("translates the supported settings", func() {
+		writeStaticfile(`root: public
+pushstate: enabled
+force_https: true
+directory: visible
+location_include: includes/*.conf
+ssi: enabled
+host_dot_files: true
+http_strict_transport_security: true
+http_strict_transport_security_include_subdomains: true
+http_strict_transport_security_preload: true
+`)
+		Expect(os.WriteFile(filepath.Join(appDir, "Staticfile.auth"), []byte("user:$apr1$x$y\n"), 0644)).To(Succeed())
+
+		staticfile, err := site.ReadStaticfile(appDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(staticfile.Notes).To(BeEmpty())
+		Expect(staticfile.Defaults).To(BeEmpty())
+		Expect(staticfile.Config).To(Equal(site.Config{
+			Root:             "public",
+			SPA:              true,
+			ForceHTTPS:       true,
+			DirectoryListing: true,
+			LocationInclude:  "nginx/conf/includes/*.conf",
+			SSI:              true,
+			BasicAuthFile:    "Staticfile.auth",
+			Headers:          map[string]string{"Strict-Transport-Security": "max-age=31536000; includeSubDomains; preload"},
+		}))
+		Expect(staticfile.Config.Validate()).To(Succeed())
+	})
+
+	It("notes the settings that are not translated", func() {
+		writeStaticfile("status_codes:\n  404: /404.html\nenable_http2: true\nhttp_strict_transport_security_preload: true\ncolour: blue\n")
+		staticfile, err := site.ReadStaticfile(appDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(staticfile.Config.Headers).To(BeEmpty())
+		Expect(staticfile.Notes).To(Equal([]string{
+			"status_codes is not supported, add error_page directives to an nginx.conf of your own instead",
+			"enable_http2 is not supported, since the platform terminates HTTP/2 before nginx",
+			"colour is not a setting that nginx-buildpack knows, so it is ignored",
+			"http_strict_transport_security_include_subdomains and http_strict_transport_security_preload are ignored without http_strict_transport_security",
+		}))
+	})
+
+	It("fails on a Staticfile that is not YAML", func() {
+		writeStaticfile("root: [")
+		_, err := site.ReadStaticfile(appDir)
+		Expect(err).To(MatchError(ContainSubstring("could not parse Staticfile")))
+	})
+})
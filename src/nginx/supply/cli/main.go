@@ -52,7 +52,7 @@ func main() {
 		os.Exit(14)
 	}
 
-	if err := stager.WriteConfigYml(nil); err != nil {
+	if err := stager.WriteConfigYml(supplier.Report); err != nil {
 		logger.Error("Error writing config.yml: %s", err.Error())
 		os.Exit(15)
 	}
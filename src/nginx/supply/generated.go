@@ -0,0 +1,43 @@
+package supply
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/netlify"
+)
+
+// generatedIncludesPath is where GenerateIncludes writes the rules that
+// {{generated_includes}} pulls in.
+func (s *Supplier) generatedIncludesPath() string {
+	return filepath.Join(s.Stager.DepDir(), "nginx", "generated-includes.conf")
+}
+
+// rulesDir is where the _redirects and _headers files are looked for: the
+// root of nginx.site, or the app itself for an nginx.conf of its own.
+func (s *Supplier) rulesDir() string {
+	if generatesConf, err := s.GeneratesConf(); err == nil && generatesConf {
+		return filepath.Join(s.Stager.BuildDir(), s.Config.Nginx.Site.RootDir())
+	}
+	return s.Stager.BuildDir()
+}
+
+// GenerateIncludes translates the _redirects and _headers files of the app
+// into nginx directives for a server block. The rules that nginx cannot
+// express are reported with their line numbers and left out.
+func (s *Supplier) GenerateIncludes() error {
+	rules, err := netlify.Read(s.rulesDir())
+	if err != nil || rules == nil {
+		return err
+	}
+
+	s.Log.BeginStep("Generating nginx rules from %s and %s, which {{generated_includes}} pulls in", netlify.RedirectsFile, netlify.HeadersFile)
+	for _, finding := range rules.Findings {
+		s.Log.Warning("%s:%d is left out: %s", finding.File, finding.Line, finding.Msg)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.generatedIncludesPath()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.generatedIncludesPath(), []byte(rules.Conf()), 0644)
+}
@@ -0,0 +1,143 @@
+package supply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+)
+
+// GetIncludedConfs returns the paths named by the include directives in conf,
+// in the order they appear.
+func GetIncludedConfs(conf *nginxconf.Config) []string {
+	includeFiles := []string{}
+	for _, d := range conf.Find("include") {
+		if len(d.Args) == 1 {
+			includeFiles = append(includeFiles, d.Arg(0))
+		}
+	}
+	return includeFiles
+}
+
+// ResolveIncludes walks the include tree of the nginx config at confPath and
+// returns every file it pulls in, not including confPath itself. Like nginx,
+// relative paths and glob patterns are resolved against the directory that
+// contains confPath, no matter which file the include appears in. Syntax
+// errors are left for nginx to report; includes that precede them are still
+// followed.
+func ResolveIncludes(confPath string) ([]string, error) {
+	r := includeResolver{
+		prefix:  filepath.Dir(confPath),
+		visited: map[string]bool{},
+		lenient: true,
+	}
+	if err := r.walk(filepath.Clean(confPath), nil); err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, conf := range r.confs[1:] {
+		files = append(files, conf.File)
+	}
+	return files, nil
+}
+
+// ParseConfTree parses the nginx config at confPath and every file it
+// includes. The config at confPath comes first, followed by the included
+// files in the order returned by ResolveIncludes.
+func ParseConfTree(confPath string) ([]*nginxconf.Config, error) {
+	r := includeResolver{
+		prefix:  filepath.Dir(confPath),
+		visited: map[string]bool{},
+	}
+	if err := r.walk(filepath.Clean(confPath), nil); err != nil {
+		return nil, err
+	}
+
+	return r.confs, nil
+}
+
+type includeResolver struct {
+	prefix  string
+	confs   []*nginxconf.Config
+	visited map[string]bool
+	lenient bool
+}
+
+func (r *includeResolver) walk(path string, stack []string) error {
+	for i, p := range stack {
+		if p == path {
+			cycle := append(append([]string{}, stack[i:]...), path)
+			return fmt.Errorf("include cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if r.visited[path] {
+		return nil
+	}
+	r.visited[path] = true
+
+	conf, err := nginxconf.ParseFile(path)
+	var syntaxErr *nginxconf.SyntaxError
+	if err != nil && !(r.lenient && errors.As(err, &syntaxErr)) {
+		return err
+	}
+	r.confs = append(r.confs, conf)
+
+	stack = append(stack, path)
+	for _, include := range GetIncludedConfs(conf) {
+		matches, err := r.expand(include)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range matches {
+			if err := r.walk(match, stack); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expand mirrors ngx_conf_include: a plain path must exist, while a glob may
+// match nothing. As with glob(3), wildcards do not match hidden files unless
+// the pattern itself starts with a dot.
+func (r *includeResolver) expand(include string) ([]string, error) {
+	path := include
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.prefix, path)
+	}
+	path = filepath.Clean(path)
+
+	if !strings.ContainsAny(include, "*?[") {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	}
+
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern %q: %w", include, err)
+	}
+
+	files := []string{}
+	showHidden := strings.HasPrefix(filepath.Base(path), ".")
+	for _, match := range matches {
+		if !showHidden && strings.HasPrefix(filepath.Base(match), ".") {
+			continue
+		}
+		if info, err := os.Stat(match); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			continue
+		}
+		files = append(files, match)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,151 @@
+package supply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/abi"
+)
+
+// moduleDependencyPrefix names the manifest dependencies of the modules in
+// nginx.modules, such as nginx-module-brotli. The version of each is the
+// nginx version that it is built for, so that the manifest lists a module
+// once for every nginx and stack it is available for.
+const moduleDependencyPrefix = "nginx-module-"
+
+var moduleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func (c NginxConfig) validateModules(dist string) error {
+	if len(c.Modules) > 0 && dist == "openresty" {
+		return errors.New("nginx.modules is only supported with nginx, not openresty")
+	}
+	for i, name := range c.Modules {
+		if !moduleNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid nginx.modules[%d] %q, expected a name such as headers-more", i, name)
+		}
+	}
+	return nil
+}
+
+// moduleDependency returns the dependency of the module name that is built
+// for nginxVersion on the stack that the app is staged on.
+func (s *Supplier) moduleDependency(name, nginxVersion string) (libbuildpack.Dependency, error) {
+	depName := moduleDependencyPrefix + name
+	versions := s.Manifest.AllDependencyVersions(depName)
+	for _, version := range versions {
+		if version == nginxVersion {
+			return libbuildpack.Dependency{Name: depName, Version: version}, nil
+		}
+	}
+
+	stack := os.Getenv("CF_STACK")
+	if len(versions) == 0 {
+		return libbuildpack.Dependency{}, fmt.Errorf("the module %s in nginx.modules is not available on %s: the buildpack's manifest has no %s dependency, put a build of the module in the app's modules directory instead",
+			name, stack, depName)
+	}
+	return libbuildpack.Dependency{}, fmt.Errorf("the module %s in nginx.modules is not built for nginx %s on %s, set nginx.version to one it is built for: %s",
+		name, nginxVersion, stack, strings.Join(versions, ", "))
+}
+
+// InstallModules installs the modules in nginx.modules, built for
+// nginxVersion, into the global modules dir, which {{module}} loads them
+// from. Every module is resolved before the first is installed, so that a
+// missing one fails staging right away.
+func (s *Supplier) InstallModules(nginxVersion string) error {
+	if len(s.Config.Nginx.Modules) == 0 {
+		return nil
+	}
+
+	deps := []libbuildpack.Dependency{}
+	for _, name := range s.Config.Nginx.Modules {
+		dep, err := s.moduleDependency(name, nginxVersion)
+		if err != nil {
+			return err
+		}
+		deps = append(deps, dep)
+	}
+
+	dir := filepath.Join(s.Stager.DepDir(), "nginx", "modules")
+	for _, dep := range deps {
+		if err := s.Installer.InstallDependency(dep, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckLocalModules checks that the installed nginx can load each module in
+// the app's modules dir, which nginx would otherwise refuse to do when it
+// starts on every instance.
+func (s *Supplier) CheckLocalModules() error {
+	paths, err := filepath.Glob(filepath.Join(s.Stager.BuildDir(), "modules", "*.so"))
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	nginxVersion, err := abi.Version(s.Report.Version)
+	if err != nil {
+		return err
+	}
+	signature, err := abi.ReadSignature(filepath.Join(s.Stager.DepDir(), "bin", "nginx"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		name := filepath.Join("modules", filepath.Base(path))
+		module, err := abi.ReadModule(path)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", name, err)
+		}
+
+		if err := abi.Check(module, nginxVersion, signature); err != nil {
+			hint := fmt.Sprintf("rebuild it with --with-compat and the configure flags that nginx -V prints for nginx %s", abi.VersionString(nginxVersion))
+			if module.Version != nginxVersion {
+				hint = fmt.Sprintf("rebuild it against nginx %s", abi.VersionString(nginxVersion))
+				if s.Config.Dist != "openresty" {
+					hint += fmt.Sprintf(" or set nginx.version to %s", abi.VersionString(module.Version))
+				}
+			}
+			return fmt.Errorf("nginx %s cannot load %s (%s): %w; %s",
+				abi.VersionString(nginxVersion), name, strings.Join(module.Names, ", "), err, hint)
+		}
+	}
+	return nil
+}
+
+// ListModules returns the modules in the user provided and the global modules
+// directories. A user provided module hides a global one of the same name,
+// like it does for {{module}}. An empty path is skipped.
+func ListModules(localModulePath, globalModulePath string) ([]ReportModule, error) {
+	found := map[string]ReportModule{}
+	for _, dir := range []struct{ source, path string }{{"global", globalModulePath}, {"local", localModulePath}} {
+		if dir.path == "" {
+			continue
+		}
+
+		paths, err := filepath.Glob(filepath.Join(dir.path, "*.so"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			name := strings.TrimSuffix(filepath.Base(path), ".so")
+			found[name] = ReportModule{Name: name, Source: dir.source, Path: path}
+		}
+	}
+
+	modules := []ReportModule{}
+	for _, m := range found {
+		modules = append(modules, m)
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Name < modules[j].Name
+	})
+	return modules, nil
+}
@@ -0,0 +1,137 @@
+package supply
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nginxErrorPattern matches the errors nginx -t prints, such as
+// `nginx: [emerg] unknown directive "foo" in /tmp/conf123/nginx.conf:47`.
+var nginxErrorPattern = regexp.MustCompile(`^nginx: \[(?:emerg|alert|crit|error)\] (.*?)(?: in (\S+):(\d+))?$`)
+
+// nginxError is an error reported by nginx, located in the app's template
+// rather than in the rendered copy nginx checked.
+type nginxError struct {
+	File string
+	Line int
+	Msg  string
+	// renderedLine is the line in the rendered copy that nginx reported.
+	renderedLine int
+}
+
+func (e nginxError) String() string {
+	if e.File == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// readLineMap reads the map varify writes with -line-map. A missing or
+// unreadable map leaves lines unmapped.
+func readLineMap(path string) map[string][]int {
+	lines := map[string][]int{}
+	if contents, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(contents, &lines)
+	}
+	return lines
+}
+
+// parseNginxErrors picks the errors out of the output of nginx -t, run
+// against the config rendered into confDir. File names are made relative
+// to confDir and lines mapped back to the templates with lines.
+func parseNginxErrors(output, confDir string, lines map[string][]int) []nginxError {
+	errs := []nginxError{}
+	for _, line := range strings.Split(output, "\n") {
+		match := nginxErrorPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		nginxErr := nginxError{Msg: strings.ReplaceAll(match[1], confDir+string(filepath.Separator), "")}
+		if match[2] != "" {
+			nginxErr.File = match[2]
+			nginxErr.Line, _ = strconv.Atoi(match[3])
+			nginxErr.renderedLine = nginxErr.Line
+			if rel, err := filepath.Rel(confDir, match[2]); err == nil && !strings.HasPrefix(rel, "..") {
+				nginxErr.File = rel
+				if m := lines[rel]; nginxErr.Line >= 1 && nginxErr.Line <= len(m) {
+					nginxErr.Line = m[nginxErr.Line-1]
+				}
+			}
+		}
+		errs = append(errs, nginxErr)
+	}
+	return errs
+}
+
+// hostNotFoundPattern matches the error nginx -t gives for an upstream host
+// that does not resolve.
+var hostNotFoundPattern = regexp.MustCompile(`^host not found in upstream "([^"]+)"`)
+
+// maxDeferredHosts bounds the number of times nginx -t is run again with an
+// unresolvable host replaced.
+const maxDeferredHosts = 32
+
+// deferUnresolvedHost replaces the upstream that e reports as unresolvable
+// with a loopback address in the rendered copy of the config in confDir, so
+// that nginx -t can check the rest. It returns the host, and false if e is
+// about something else or the upstream could not be replaced.
+func deferUnresolvedHost(confDir string, e nginxError) (string, bool) {
+	match := hostNotFoundPattern.FindStringSubmatch(e.Msg)
+	if match == nil || e.File == "" || filepath.IsAbs(e.File) {
+		return "", false
+	}
+
+	upstream := match[1]
+	host, port := upstream, ""
+	if i := strings.LastIndex(upstream, ":"); i >= 0 {
+		if _, err := strconv.Atoi(upstream[i+1:]); err == nil {
+			host, port = upstream[:i], upstream[i:]
+		}
+	}
+
+	path := filepath.Join(confDir, e.File)
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	lines := strings.Split(string(contents), "\n")
+	if e.renderedLine < 1 || e.renderedLine > len(lines) || !strings.Contains(lines[e.renderedLine-1], upstream) {
+		return "", false
+	}
+	lines[e.renderedLine-1] = strings.Replace(lines[e.renderedLine-1], upstream, "127.0.0.1"+port, 1)
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return "", false
+	}
+	return host, true
+}
+
+// sourceSnippet returns the lines around line of the file at path, with the
+// line itself marked.
+func sourceSnippet(path string, line int) string {
+	contents, err := os.ReadFile(path)
+	if err != nil || line < 1 {
+		return ""
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	if line > len(lines) {
+		return ""
+	}
+
+	snippet := strings.Builder{}
+	for i := max(line-2, 1); i <= min(line+2, len(lines)); i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&snippet, "%s %4d | %s\n", marker, i, lines[i-1])
+	}
+	return strings.TrimSuffix(snippet.String(), "\n")
+}
@@ -0,0 +1,167 @@
+package supply
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/lint"
+)
+
+// Report describes what was staged, for the buildpacks that run after this
+// one and for tooling that inspects droplets. It is written to
+// DepDir/nginx/staging-report.json and is the config of config.yml.
+type Report struct {
+	// SupplyOnly is set when nginx was only supplied to a later buildpack,
+	// in which case there are no Confs or Lint findings unless nginx runs
+	// as a Sidecar.
+	SupplyOnly       bool   `json:"supply_only" yaml:"supply_only"`
+	Sidecar          bool   `json:"sidecar" yaml:"sidecar"`
+	Dist             string `json:"dist" yaml:"dist"`
+	RequestedVersion string `json:"requested_version" yaml:"requested_version"`
+	Version          string `json:"version" yaml:"version"`
+	// VersionLine is the version line, such as mainline, that Version
+	// belongs to, if any.
+	VersionLine string         `json:"version_line" yaml:"version_line"`
+	Modules     []ReportModule `json:"modules" yaml:"modules"`
+	Confs       []string       `json:"confs" yaml:"confs"`
+	// Variants of the main config, one of which the launcher can run
+	// instead.
+	Variants         []string        `json:"variants" yaml:"variants"`
+	Lint             []ReportFinding `json:"lint" yaml:"lint"`
+	PlaintextEnvVars []string        `json:"plaintext_env_vars" yaml:"plaintext_env_vars"`
+}
+
+// ReportModule is a dynamic module that {{module}} can load. Path is relative
+// to DepDir for the modules shipped with nginx and to the app for the ones
+// the app provides.
+type ReportModule struct {
+	Name   string `json:"name" yaml:"name"`
+	Source string `json:"source" yaml:"source"`
+	Path   string `json:"path" yaml:"path"`
+}
+
+// ReportFinding is a lint finding. File and Line are left out when the
+// finding is about something missing from the config.
+type ReportFinding struct {
+	File     string `json:"file,omitempty" yaml:"file,omitempty"`
+	Line     int    `json:"line,omitempty" yaml:"line,omitempty"`
+	Rule     string `json:"rule" yaml:"rule"`
+	Severity string `json:"severity" yaml:"severity"`
+	Message  string `json:"message" yaml:"message"`
+}
+
+func reportFindings(findings []lint.Finding) []ReportFinding {
+	report := []ReportFinding{}
+	for _, f := range findings {
+		report = append(report, ReportFinding{
+			File:     f.Pos.File,
+			Line:     f.Pos.Line,
+			Rule:     f.RuleID,
+			Severity: f.Severity.String(),
+			Message:  f.Message,
+		})
+	}
+	return report
+}
+
+// ReadReport reads the report that supply wrote, for finalize to complete.
+func (s *Supplier) ReadReport() error {
+	contents, err := os.ReadFile(s.reportPath())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, &s.Report)
+}
+
+func (s *Supplier) reportPath() string {
+	return filepath.Join(s.Stager.DepDir(), "nginx", "staging-report.json")
+}
+
+// WriteReport completes Report with what is known once nginx.conf has been
+// validated and writes it to DepDir/nginx/staging-report.json.
+func (s *Supplier) WriteReport() error {
+	modules, err := s.reportModules()
+	if err != nil {
+		return err
+	}
+	s.Report.Modules = modules
+
+	s.Report.Confs = []string{}
+	s.Report.Variants = []string{}
+	generatesConf, err := s.GeneratesConf()
+	if err != nil {
+		return err
+	}
+	// a generated config is reported once finalize has written it
+	if (!s.SupplyOnly || s.Config.Nginx.Sidecar.Enabled) && !generatesConf {
+		s.Report.Variants = append(s.Report.Variants, s.Config.Nginx.Variants...)
+
+		confs, err := ParseConfTree(s.confPath())
+		if err != nil {
+			return err
+		}
+		for _, conf := range confs {
+			if rel, err := filepath.Rel(s.displayDir(s.confPath()), conf.File); err == nil {
+				s.Report.Confs = append(s.Report.Confs, rel)
+			}
+		}
+	}
+
+	s.Report.PlaintextEnvVars = append([]string{}, s.Config.Nginx.PlaintextEnvVars...)
+	if s.Report.Lint == nil {
+		s.Report.Lint = []ReportFinding{}
+	}
+
+	contents, err := json.MarshalIndent(s.Report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.reportPath(), contents, 0644)
+}
+
+// reportModules lists the modules like varify modules does, with paths
+// relative to DepDir for the modules shipped with nginx and to the app for
+// the ones it provides.
+func (s *Supplier) reportModules() ([]ReportModule, error) {
+	modules, err := ListModules(filepath.Join(s.Stager.BuildDir(), "modules"), filepath.Join(s.Stager.DepDir(), "nginx", "modules"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range modules {
+		base := s.Stager.DepDir()
+		if m.Source == "local" {
+			base = s.Stager.BuildDir()
+		}
+		if modules[i].Path, err = filepath.Rel(base, m.Path); err != nil {
+			return nil, err
+		}
+	}
+	return modules, nil
+}
+
+// versionLine returns the name of the version line that version belongs to,
+// preferring the one that was requested.
+func (s *Supplier) versionLine(requested, version string) string {
+	if _, ok := s.VersionLines[requested]; ok && requested != "" {
+		return requested
+	}
+
+	names := []string{}
+	for name := range s.VersionLines {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := libbuildpack.FindMatchingVersion(s.VersionLines[name], []string{version}); err == nil {
+			return name
+		}
+	}
+	return ""
+}
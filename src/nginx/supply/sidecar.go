@@ -0,0 +1,164 @@
+package supply
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// SidecarConfig is nginx.sidecar in buildpack.yml, which runs nginx as a
+// reverse proxy in front of an app that a later buildpack stages. It is
+// either true, to use the defaults, or a map.
+type SidecarConfig struct {
+	Enabled bool `yaml:"-"`
+	// AppPort is the port that the app listens on behind nginx.
+	AppPort int `yaml:"app_port"`
+	// AppPortEnv names the variable that tells the app AppPort.
+	AppPortEnv string `yaml:"app_port_env"`
+	// Conf is an nginx.conf template in the app that replaces the default
+	// reverse proxy config.
+	Conf string `yaml:"conf"`
+}
+
+const (
+	defaultSidecarAppPort    = 8081
+	defaultSidecarAppPortEnv = "APP_PORT"
+	// sidecarAppPortEnv is always set to the app port, so that templates
+	// can proxy to the app whatever AppPortEnv is.
+	sidecarAppPortEnv = "NGINX_APP_PORT"
+)
+
+// defaultSidecarConf proxies every request to the app.
+const defaultSidecarConf = `worker_processes 1;
+daemon off;
+
+error_log stderr;
+events { worker_connections 1024; }
+
+http {
+  charset utf-8;
+  log_format cloudfoundry 'NginxLog "$request" $status $body_bytes_sent';
+  access_log /dev/stdout cloudfoundry;
+  default_type application/octet-stream;
+  sendfile on;
+
+  tcp_nopush on;
+  keepalive_timeout 30;
+  port_in_redirect off; # Ensure that redirects don't include the internal container PORT - 8080
+
+  server {
+    listen {{port}};
+
+    location / {
+      proxy_pass http://127.0.0.1:{{env "NGINX_APP_PORT"}};
+      proxy_http_version 1.1;
+      proxy_set_header Host $host;
+      proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+      proxy_set_header X-Forwarded-Proto $http_x_forwarded_proto;
+    }
+  }
+}
+`
+
+func (c *SidecarConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		c.Enabled = enabled
+		return nil
+	}
+
+	type plain SidecarConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	c.Enabled = true
+	return nil
+}
+
+func (c SidecarConfig) appPort() int {
+	if c.AppPort == 0 {
+		return defaultSidecarAppPort
+	}
+	return c.AppPort
+}
+
+func (c SidecarConfig) appPortEnv() string {
+	if c.AppPortEnv == "" {
+		return defaultSidecarAppPortEnv
+	}
+	return c.AppPortEnv
+}
+
+func (c SidecarConfig) validate() error {
+	if c.AppPort < 0 || c.AppPort > 65535 {
+		return fmt.Errorf("invalid nginx.sidecar.app_port %d", c.AppPort)
+	}
+	if c.AppPortEnv == "PORT" {
+		return errors.New("nginx.sidecar.app_port_env cannot be PORT, which nginx listens on")
+	}
+	if filepath.IsAbs(c.Conf) {
+		return fmt.Errorf("nginx.sidecar.conf %q must be relative to the app", c.Conf)
+	}
+	return nil
+}
+
+// InstallSidecar sets nginx up to run next to the app: it writes the default
+// config unless the app brings its own, tells the app which port to listen
+// on and adds the nginx process to launch.yml.
+func (s *Supplier) InstallSidecar() error {
+	sidecar := s.Config.Nginx.Sidecar
+	s.Log.BeginStep("Running nginx as a sidecar in front of the app on port %d", sidecar.appPort())
+
+	runtimeConfPath := filepath.Join("$HOME", sidecar.Conf)
+	if sidecar.Conf == "" {
+		if err := os.MkdirAll(filepath.Dir(s.confPath()), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(s.confPath(), []byte(defaultSidecarConf), 0644); err != nil {
+			return err
+		}
+		runtimeConfPath = fmt.Sprintf("$DEPS_DIR/%s/nginx/sidecar/nginx.conf", s.Stager.DepsIdx())
+	}
+
+	env := fmt.Sprintf("export %s=%d\n", sidecarAppPortEnv, sidecar.appPort())
+	if sidecar.appPortEnv() != sidecarAppPortEnv {
+		env += fmt.Sprintf("export %s=%d\n", sidecar.appPortEnv(), sidecar.appPort())
+	}
+	if err := s.Stager.WriteProfileD("nginx-sidecar", env); err != nil {
+		return err
+	}
+
+	return s.writeLaunchConfig(runtimeConfPath)
+}
+
+type launchConfig struct {
+	Processes []launchProcess `yaml:"processes"`
+}
+
+type launchProcess struct {
+	Type      string `yaml:"type"`
+	Command   string `yaml:"command"`
+	Platforms struct {
+		Cloudfoundry struct {
+			SidecarFor []string `yaml:"sidecar_for"`
+		} `yaml:"cloudfoundry"`
+	} `yaml:"platforms"`
+}
+
+// writeLaunchConfig adds nginx as a sidecar of the web process in
+// DepDir/launch.yml, started by the launcher with the template at confPath.
+func (s *Supplier) writeLaunchConfig(confPath string) error {
+	depDir := "$DEPS_DIR/" + s.Stager.DepsIdx()
+
+	process := launchProcess{
+		Type: "nginx",
+		Command: fmt.Sprintf("%[1]s/bin/launcher -varify %[1]s/bin/varify -nginx %[1]s/bin/nginx -buildpack-yml-path $HOME/buildpack.yml -conf %[2]s -output-dir /tmp/nginx-sidecar -local-modules $HOME/modules -global-modules %[1]s/nginx/modules -generated-includes %[1]s/nginx/generated-includes.conf -deferred-hosts %[1]s/nginx/deferred-hosts.json",
+			depDir, confPath),
+	}
+	process.Platforms.Cloudfoundry.SidecarFor = []string{"web"}
+
+	return libbuildpack.NewYAML().Write(filepath.Join(s.Stager.DepDir(), "launch.yml"), launchConfig{Processes: []launchProcess{process}})
+}
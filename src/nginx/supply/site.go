@@ -0,0 +1,60 @@
+package supply
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
+)
+
+// GeneratesConf reports whether nginx.conf is generated from nginx.site,
+// which is the case when the app has the section, or a Staticfile, but no
+// nginx.conf.
+func (s *Supplier) GeneratesConf() (bool, error) {
+	if s.Config.Nginx.Site == nil || s.Config.Nginx.Sidecar.Enabled {
+		return false, nil
+	}
+
+	exists, err := libbuildpack.FileExists(s.confPath())
+	return !exists, err
+}
+
+// GenerateConf writes the nginx.conf that nginx.site describes.
+func (s *Supplier) GenerateConf() error {
+	s.Log.BeginStep("Generating %s from %s", s.displayPath(s.confPath()), s.siteSource())
+
+	if err := os.MkdirAll(filepath.Dir(s.confPath()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.confPath(), []byte(site.Generate(*s.Config.Nginx.Site)), 0644)
+}
+
+// siteSource names where nginx.site comes from in messages.
+func (s *Supplier) siteSource() string {
+	if s.Staticfile != nil {
+		return "Staticfile"
+	}
+	return "nginx.site in buildpack.yml"
+}
+
+// logStaticfileNotes reports the defaults chosen for the Staticfile and the
+// settings that were not carried over, so that the app can be migrated off
+// the staticfile buildpack.
+func (s *Supplier) logStaticfileNotes() {
+	if s.Staticfile == nil {
+		return
+	}
+
+	s.Log.BeginStep("Translating the Staticfile of the staticfile buildpack")
+	for _, choice := range s.Staticfile.Defaults {
+		s.Log.Info("Staticfile: %s", choice)
+	}
+	if len(s.Staticfile.Notes) == 0 {
+		s.Log.Info("Every setting in the Staticfile is supported")
+		return
+	}
+	for _, note := range s.Staticfile.Notes {
+		s.Log.Warning("Staticfile: %s", note)
+	}
+}
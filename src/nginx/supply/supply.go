@@ -9,11 +9,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/launcher"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/lint"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
 )
 
 type Command interface {
@@ -50,8 +53,37 @@ type Config struct {
 
 type NginxConfig struct {
 	Version string `yaml:"version"`
+	// ConfigPath is where the app keeps its nginx.conf, relative to the app.
+	// The variants in Variants, such as nginx.production.conf, are next to
+	// it.
+	ConfigPath       string      `yaml:"config_path"`
+	Lint             lint.Config `yaml:"lint"`
+	PlaintextEnvVars []string    `yaml:"plaintext_env_vars"`
+	// SupplyOnly overrides whether nginx is only supplied to a later
+	// buildpack, which is otherwise detected.
+	SupplyOnly *bool         `yaml:"supply_only"`
+	Sidecar    SidecarConfig `yaml:"sidecar"`
+	// Site describes the site that nginx.conf is generated for when the app
+	// has none.
+	Site *site.Config `yaml:"site"`
+	// Modules names third party modules, such as brotli, to install from
+	// the manifest for {{module}} to load.
+	Modules []string `yaml:"modules"`
+	// Variants names the variants of nginx.conf that the launcher can run
+	// instead of it, such as production for nginx.production.conf.
+	Variants []string `yaml:"variants"`
 }
 
+func (c NginxConfig) configPath() string {
+	if c.ConfigPath == "" {
+		return "nginx.conf"
+	}
+	return c.ConfigPath
+}
+
+// appDir is where the app lives once it runs.
+const appDir = "/home/vcap/app"
+
 type Supplier struct {
 	Stager       Stager
 	Manifest     Manifest
@@ -60,6 +92,12 @@ type Supplier struct {
 	Config       Config
 	Command      Command
 	VersionLines map[string]string
+	Report       Report
+	// SupplyOnly is set when a later buildpack serves the app, so that the
+	// app has no nginx.conf of its own to validate.
+	SupplyOnly bool
+	// Staticfile is set when the app's Staticfile stands in for nginx.site.
+	Staticfile *site.Staticfile
 }
 
 func New(stager Stager, manifest Manifest, installer Installer, logger *libbuildpack.Logger, command Command) *Supplier {
@@ -80,6 +118,11 @@ func (s *Supplier) Run() error {
 		return err
 	}
 
+	if err := s.InstallLauncher(); err != nil {
+		s.Log.Error("Failed to copy launcher: %s", err.Error())
+		return err
+	}
+
 	if err := s.Setup(); err != nil {
 		s.Log.Error("Could not setup: %s", err.Error())
 		return err
@@ -97,8 +140,44 @@ func (s *Supplier) Run() error {
 		}
 	}
 
-	if err := s.ValidateNginxConf(); err != nil {
-		s.Log.Error("Could not validate nginx.conf: %s", err.Error())
+	if !s.SupplyOnly || s.Config.Nginx.Sidecar.Enabled {
+		if err := s.CheckLocalModules(); err != nil {
+			s.Log.Error("Incompatible module: %s", err.Error())
+			return err
+		}
+	}
+
+	if s.Config.Nginx.Sidecar.Enabled {
+		if err := s.InstallSidecar(); err != nil {
+			s.Log.Error("Could not install the nginx sidecar: %s", err.Error())
+			return err
+		}
+	}
+
+	if !s.SupplyOnly || s.Config.Nginx.Sidecar.Enabled {
+		if err := s.GenerateIncludes(); err != nil {
+			s.Log.Error("Could not generate rules from _redirects and _headers: %s", err.Error())
+			return err
+		}
+	}
+
+	generatesConf, err := s.GeneratesConf()
+	if err != nil {
+		s.Log.Error("Could not find nginx.conf: %s", err.Error())
+		return err
+	}
+
+	if generatesConf {
+		s.Log.Info("nginx.conf is generated from %s and validated once the app is finalized", s.siteSource())
+	} else if !s.SupplyOnly || s.Config.Nginx.Sidecar.Enabled {
+		if err := s.ValidateNginxConf(); err != nil {
+			s.Log.Error("Could not validate nginx.conf: %s", err.Error())
+			return err
+		}
+	}
+
+	if err := s.WriteReport(); err != nil {
+		s.Log.Error("Could not write staging report: %s", err.Error())
 		return err
 	}
 
@@ -129,17 +208,109 @@ func (s *Supplier) WriteProfileD() error {
 }
 
 func (s *Supplier) InstallVarify() error {
-	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.DepDir(), "bin", "varify")); err != nil {
+	return s.installBuildpackBinary("varify")
+}
+
+func (s *Supplier) InstallLauncher() error {
+	return s.installBuildpackBinary("launcher")
+}
+
+func (s *Supplier) installBuildpackBinary(name string) error {
+	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.DepDir(), "bin", name)); err != nil {
 		return err
 	} else if exists {
 		return nil
 	}
 
-	return libbuildpack.CopyFile(filepath.Join(s.Manifest.RootDir(), "bin", "varify"), filepath.Join(s.Stager.DepDir(), "bin", "varify"))
+	return libbuildpack.CopyFile(filepath.Join(s.Manifest.RootDir(), "bin", name), filepath.Join(s.Stager.DepDir(), "bin", name))
 }
 
 func (s *Supplier) Setup() error {
-	configPath := filepath.Join(s.Stager.BuildDir(), "buildpack.yml")
+	if err := s.LoadConfig(s.Stager.BuildDir()); err != nil {
+		return err
+	}
+
+	if s.Config.Nginx.Site != nil {
+		if err := s.Config.Nginx.Site.Validate(); err != nil {
+			if s.Staticfile != nil {
+				return fmt.Errorf("could not translate the Staticfile: %w", err)
+			}
+			return err
+		}
+	}
+	s.logStaticfileNotes()
+
+	if err := s.Config.Nginx.validateModules(s.Config.Dist); err != nil {
+		return err
+	}
+	for _, variant := range s.Config.Nginx.Variants {
+		if err := launcher.ValidateVariant(variant); err != nil {
+			return err
+		}
+	}
+
+	if configPath := filepath.Clean(s.Config.Nginx.configPath()); filepath.IsAbs(configPath) || strings.HasPrefix(configPath, "..") {
+		return fmt.Errorf("nginx.config_path %q must be inside the app", s.Config.Nginx.ConfigPath)
+	}
+
+	supplyOnly, err := s.isSupplyOnly()
+	if err != nil {
+		return err
+	}
+	s.SupplyOnly = supplyOnly
+	s.Report.SupplyOnly = supplyOnly
+	s.Report.Sidecar = s.Config.Nginx.Sidecar.Enabled
+	if s.Config.Nginx.Sidecar.Enabled {
+		if !supplyOnly {
+			return errors.New("nginx.sidecar needs a buildpack after nginx to stage the app")
+		}
+		if err := s.Config.Nginx.Sidecar.validate(); err != nil {
+			return err
+		}
+	}
+	if supplyOnly {
+		s.Log.Info("nginx is not the final buildpack, so it is only supplied to the buildpacks after it and nginx.conf is not required")
+		return nil
+	}
+
+	logsDirPath := filepath.Join(s.Stager.BuildDir(), "logs")
+	if err := os.Mkdir(logsDirPath, os.ModePerm); err != nil {
+		return fmt.Errorf("Could not create 'logs' directory: %v", err)
+	}
+
+	return nil
+}
+
+// isSupplyOnly reports whether a later buildpack serves the app, as set by
+// nginx.supply_only in buildpack.yml. Without it, nginx is only supplied if
+// the app is staged with buildpacks after this one, since the lifecycle
+// creates a deps dir for each of them before supplying.
+func (s *Supplier) isSupplyOnly() (bool, error) {
+	if s.Config.Nginx.SupplyOnly != nil {
+		return *s.Config.Nginx.SupplyOnly, nil
+	}
+
+	idx, err := strconv.Atoi(s.Stager.DepsIdx())
+	if err != nil {
+		return false, fmt.Errorf("invalid deps index %q", s.Stager.DepsIdx())
+	}
+
+	entries, err := os.ReadDir(s.Stager.DepsDir())
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if other, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() && other > idx {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LoadConfig reads buildpack.yml from the app in buildDir, if it has one, and
+// the version lines from the buildpack manifest.
+func (s *Supplier) LoadConfig(buildDir string) error {
+	configPath := filepath.Join(buildDir, "buildpack.yml")
 	if exists, err := libbuildpack.FileExists(configPath); err != nil {
 		return err
 	} else if exists {
@@ -156,43 +327,174 @@ func (s *Supplier) Setup() error {
 	}
 	s.VersionLines = m.VersionLines
 
-	logsDirPath := filepath.Join(s.Stager.BuildDir(), "logs")
-	if err := os.Mkdir(logsDirPath, os.ModePerm); err != nil {
-		return fmt.Errorf("Could not create 'logs' directory: %v", err)
+	if s.Config.Nginx.Site == nil {
+		staticfile, err := site.ReadStaticfile(buildDir)
+		if err != nil {
+			return err
+		}
+		if staticfile != nil {
+			s.Staticfile = staticfile
+			s.Config.Nginx.Site = &staticfile.Config
+		}
 	}
-
 	return nil
 }
 
+// SelectedDependency returns the nginx or OpenResty dependency that the
+// config selects.
+func (s *Supplier) SelectedDependency() (libbuildpack.Dependency, error) {
+	if s.Config.Dist == "openresty" {
+		return s.openRestyDependency()
+	}
+	return s.findMatchingVersion("nginx", s.Config.Nginx.Version)
+}
+
+// ValidateNginxConf checks nginx.conf and each of its variants, which the
+// launcher picks from at runtime.
 func (s *Supplier) ValidateNginxConf() error {
-	if err := s.validateNginxConfHasPort(); err != nil {
-		s.Log.Error("The listen port value in nginx.conf must be configured to the template `{{port}}`")
-		return fmt.Errorf("validation of port `{{port}}` failed: %w", err)
+	confPaths, err := s.confPaths()
+	if err != nil {
+		return err
 	}
 
-	if err := s.validateNGINXConfSyntax(); err != nil {
-		return fmt.Errorf("validation of nginx conf syntax failed: %w", err)
+	deferred := []launcher.DeferredHost{}
+	for _, confPath := range confPaths {
+		if len(confPaths) > 1 {
+			s.Log.BeginStep("Validating %s", s.displayPath(confPath))
+		}
+
+		if err := s.validateNginxConfHasPort(confPath); err != nil {
+			s.Log.Error("The listen port value in %s must be configured to the template `{{port}}`", s.displayPath(confPath))
+			return fmt.Errorf("validation of port `{{port}}` failed: %w", err)
+		}
+
+		hosts, err := s.validateNGINXConfSyntax(confPath)
+		if err != nil {
+			return fmt.Errorf("validation of nginx conf syntax failed: %w", err)
+		}
+		deferred = append(deferred, hosts...)
+	}
+
+	if err := launcher.WriteDeferredHosts(filepath.Join(s.Stager.DepDir(), "nginx", "deferred-hosts.json"), deferred); err != nil {
+		return err
+	}
+
+	return s.Lint()
+}
+
+// confPath is the nginx.conf template that nginx runs with: the app's own,
+// at nginx.config_path or, in sidecar mode, the sidecar's.
+func (s *Supplier) confPath() string {
+	sidecar := s.Config.Nginx.Sidecar
+	switch {
+	case !sidecar.Enabled:
+		return filepath.Join(s.Stager.BuildDir(), s.Config.Nginx.configPath())
+	case sidecar.Conf != "":
+		return filepath.Join(s.Stager.BuildDir(), sidecar.Conf)
+	default:
+		return filepath.Join(s.Stager.DepDir(), "nginx", "sidecar", "nginx.conf")
+	}
+}
+
+// confPaths returns confPath followed by the variants in nginx.variants,
+// each of which must exist.
+func (s *Supplier) confPaths() ([]string, error) {
+	paths := []string{s.confPath()}
+	for _, variant := range s.Config.Nginx.Variants {
+		path := launcher.VariantPath(s.confPath(), variant)
+		if exists, err := libbuildpack.FileExists(path); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, fmt.Errorf("nginx.variants lists %s, but %s does not exist", variant, s.displayPath(path))
+		}
+		paths = append(paths, path)
 	}
+	return paths, nil
+}
+
+// displayDir is the dir that file names in messages about the config at
+// confPath are relative to: the app, unless the config is not part of it.
+func (s *Supplier) displayDir(confPath string) string {
+	if rel, err := filepath.Rel(s.Stager.BuildDir(), confPath); err == nil && !strings.HasPrefix(rel, "..") {
+		return s.Stager.BuildDir()
+	}
+	return filepath.Dir(confPath)
+}
+
+func (s *Supplier) displayPath(confPath string) string {
+	rel, _ := filepath.Rel(s.displayDir(confPath), confPath)
+	return rel
+}
 
-	return s.CheckAccessLogging()
+// Lint checks nginx.conf, its variants and the files they include with
+// lint.Rules, as configured by nginx.lint in buildpack.yml. It logs every
+// finding and fails if any of them is an error.
+func (s *Supplier) Lint() error {
+	findings, err := s.lint(lint.Rules)
+	s.Report.Lint = reportFindings(findings)
+	return err
 }
 
 func (s *Supplier) CheckAccessLogging() error {
-	contents, err := os.ReadFile(filepath.Join(s.Stager.BuildDir(), "nginx.conf"))
+	_, err := s.lint([]lint.Rule{lint.AccessLog})
+	return err
+}
+
+func (s *Supplier) lint(rules []lint.Rule) ([]lint.Finding, error) {
+	confPaths, err := s.confPaths()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	isSetToOff, err := regexp.MatchString(`(?i)access_log\s+off`, string(contents))
-	if err != nil {
-		return err
+	findings := []lint.Finding{}
+	for _, confPath := range confPaths {
+		confFindings, err := s.lintConf(confPath, rules)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, confFindings...)
 	}
 
-	if !strings.Contains(string(contents), "access_log") || isSetToOff {
-		s.Log.Warning("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug.")
+	for _, f := range findings {
+		if f.Severity == lint.Error {
+			s.Log.Error("%s\n  %s", f, f.Hint)
+		} else {
+			s.Log.Warning("Warning: %s\n  %s", f, f.Hint)
+		}
 	}
 
-	return nil
+	if lint.HasErrors(findings) {
+		return findings, errors.New("nginx.conf has lint errors, see above")
+	}
+	return findings, nil
+}
+
+func (s *Supplier) lintConf(confPath string, rules []lint.Rule) ([]lint.Finding, error) {
+	confs, err := ParseConfTree(confPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &lint.Context{
+		Confs:       confs,
+		AppDir:      appDir,
+		StagingDirs: []string{s.Stager.BuildDir(), s.Stager.DepDir()},
+	}
+	findings, err := lint.Run(c, rules, s.Config.Nginx.Lint)
+	if err != nil {
+		return nil, err
+	}
+	if confPath != s.confPath() {
+		// findings about something missing from a variant name no file,
+		// which would leave the variant unnamed
+		for i := range findings {
+			if findings[i].Pos.File == "" {
+				findings[i].Pos.File = confPath
+			}
+		}
+	}
+	lint.RelativeTo(s.displayDir(confPath), findings)
+	return findings, nil
 }
 
 func (s *Supplier) InstallNGINX() error {
@@ -207,6 +509,11 @@ func (s *Supplier) InstallNGINX() error {
 		s.Log.BeginStep("Requested nginx version: %s => %s", s.Config.Nginx.Version, dep.Version)
 	}
 
+	s.Report.Dist = "nginx"
+	s.Report.RequestedVersion = s.Config.Nginx.Version
+	s.Report.Version = dep.Version
+	s.Report.VersionLine = s.versionLine(s.Config.Nginx.Version, dep.Version)
+
 	dir := filepath.Join(s.Stager.DepDir(), "nginx")
 
 	if s.isStableLine(dep.Version) {
@@ -217,16 +524,20 @@ func (s *Supplier) InstallNGINX() error {
 		return err
 	}
 
+	if err := s.InstallModules(dep.Version); err != nil {
+		return err
+	}
+
 	return s.Stager.AddBinDependencyLink(filepath.Join(dir, "sbin", "nginx"), "nginx")
 }
 
 func (s *Supplier) InstallOpenResty() error {
-	versions := s.Manifest.AllDependencyVersions("openresty")
-	if len(versions) < 1 {
-		return fmt.Errorf("unable to find a version of openresty to install")
+	dep, err := s.openRestyDependency()
+	if err != nil {
+		return err
 	}
-
-	dep := libbuildpack.Dependency{Name: "openresty", Version: versions[len(versions)-1]}
+	s.Report.Dist = "openresty"
+	s.Report.Version = dep.Version
 	dir := filepath.Join(s.Stager.DepDir(), "nginx")
 	if err := s.Installer.InstallDependency(dep, dir); err != nil {
 		return err
@@ -235,54 +546,55 @@ func (s *Supplier) InstallOpenResty() error {
 	return s.Stager.AddBinDependencyLink(filepath.Join(dir, "nginx", "sbin", "nginx"), "nginx")
 }
 
-func (s *Supplier) validateNginxConfHasPort() error {
+func (s *Supplier) openRestyDependency() (libbuildpack.Dependency, error) {
+	versions := s.Manifest.AllDependencyVersions("openresty")
+	if len(versions) < 1 {
+		return libbuildpack.Dependency{}, fmt.Errorf("unable to find a version of openresty to install")
+	}
+
+	return libbuildpack.Dependency{Name: "openresty", Version: versions[len(versions)-1]}, nil
+}
+
+func (s *Supplier) validateNginxConfHasPort(confPath string) error {
 	tmpDir, err := os.MkdirTemp("", "")
 	if err != nil {
 		return fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	if err := libbuildpack.CopyDirectory(s.Stager.BuildDir(), tmpDir); err != nil {
+	if err := libbuildpack.CopyDirectory(filepath.Dir(confPath), tmpDir); err != nil {
 		return fmt.Errorf("Error copying nginx.conf: %s", err.Error())
 	}
-	nginxConfPath := filepath.Join(tmpDir, "nginx.conf")
+	nginxConfPath := filepath.Join(tmpDir, filepath.Base(confPath))
 
 	randString := randomString(16)
-	cmd := exec.Command(filepath.Join(s.Stager.DepDir(), "bin", "varify"), "-buildpack-yml-path", "", nginxConfPath, "", "")
+	cmd := exec.Command(filepath.Join(s.Stager.DepDir(), "bin", "varify"), "render",
+		"-conf", nginxConfPath,
+		"-buildpack-yml-path", filepath.Join(s.Stager.BuildDir(), "buildpack.yml"),
+		"-validation",
+	)
 	cmd.Dir = tmpDir
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PORT=%s", randString))
 	if output, err := s.Command.RunWithOutput(cmd); err != nil {
 		return fmt.Errorf("varify command failed: %w\noutput: %s", err, string(output))
 	}
 
-	confContents, err := os.ReadFile(nginxConfPath)
+	confs, err := ParseConfTree(nginxConfPath)
 	if err != nil {
-		return fmt.Errorf("error reading temp config file: %w", err)
+		return fmt.Errorf("error parsing temp config files: %w", err)
 	}
 
-	configFiles := GetIncludedConfs(string(confContents))
-	configFiles = append(configFiles, nginxConfPath)
-
-	foundPort := false
-	for _, confFile := range configFiles {
-		if !filepath.IsAbs(confFile) {
-			confFile = filepath.Join(tmpDir, confFile)
-		}
-		contents, err := os.ReadFile(confFile)
-		if err != nil {
-			return fmt.Errorf("error reading temp config file %s: %w", confFile, err)
-		}
-		if strings.Contains(string(contents), randString) {
-			foundPort = true
-			break
+	for _, conf := range confs {
+		for _, d := range conf.Find("listen") {
+			for _, arg := range d.Args {
+				if strings.Contains(arg.Value, randString) {
+					return nil
+				}
+			}
 		}
 	}
 
-	if !foundPort {
-		return errors.New("no `{{port}}` in nginx.conf")
-	}
-
-	return nil
+	return fmt.Errorf("no `{{port}}` in %s", s.displayPath(confPath))
 }
 
 func randomString(strLength int) string {
@@ -297,45 +609,95 @@ func randomString(strLength int) string {
 	return randString
 }
 
-func (s *Supplier) validateNGINXConfSyntax() error {
+// validateNGINXConfSyntax checks the config at confPath with nginx -t. It
+// returns the hosts that did not resolve while staging.
+func (s *Supplier) validateNGINXConfSyntax(confPath string) ([]launcher.DeferredHost, error) {
 	tmpConfDir, err := os.MkdirTemp("/tmp", "conf")
 	if err != nil {
-		return fmt.Errorf("Error creating temp nginx conf dir: %s", err.Error())
+		return nil, fmt.Errorf("Error creating temp nginx conf dir: %s", err.Error())
 	}
 	defer os.RemoveAll(tmpConfDir)
 
-	if err := libbuildpack.CopyDirectory(s.Stager.BuildDir(), tmpConfDir); err != nil {
-		return fmt.Errorf("Error copying nginx.conf: %s", err.Error())
+	if err := libbuildpack.CopyDirectory(filepath.Dir(confPath), tmpConfDir); err != nil {
+		return nil, fmt.Errorf("Error copying nginx.conf: %s", err.Error())
 	}
+	// the temp dir stands in for the dir of confPath, which messages name
+	// relative to displayDir
+	relDir, _ := filepath.Rel(s.displayDir(confPath), filepath.Dir(confPath))
 
-	nginxConfPath := filepath.Join(tmpConfDir, "nginx.conf")
+	nginxConfPath := filepath.Join(tmpConfDir, filepath.Base(confPath))
 	localModulePath := filepath.Join(s.Stager.BuildDir(), "modules")
 	globalModulePath := filepath.Join(s.Stager.DepDir(), "nginx", "modules")
 	buildpackYMLPath := filepath.Join(s.Stager.BuildDir(), "buildpack.yml")
-	cmd := exec.Command(filepath.Join(s.Stager.DepDir(), "bin", "varify"), "-buildpack-yml-path", buildpackYMLPath, nginxConfPath, localModulePath, globalModulePath)
+	lineMapPath := tmpConfDir + "-lines.json"
+	defer os.Remove(lineMapPath)
+	cmd := exec.Command(filepath.Join(s.Stager.DepDir(), "bin", "varify"), "render",
+		"-conf", nginxConfPath,
+		"-buildpack-yml-path", buildpackYMLPath,
+		"-local-modules", localModulePath,
+		"-global-modules", globalModulePath,
+		"-generated-includes", s.generatedIncludesPath(),
+		"-line-map", lineMapPath,
+		"-validation",
+	)
 	cmd.Dir = tmpConfDir
 	cmd.Stdout = io.Discard
 	cmd.Stderr = io.Discard
 	cmd.Env = append(os.Environ(), "PORT=8080")
 	if err := s.Command.Run(cmd); err != nil {
-		return err
+		return nil, err
 	}
 
-	nginxErr := &bytes.Buffer{}
+	// Hosts that do not resolve while staging, such as internal routes, are
+	// replaced one by one until nginx -t gets past them.
+	deferred := []launcher.DeferredHost{}
+	for {
+		nginxErr := &bytes.Buffer{}
 
-	cmd = exec.Command(filepath.Join(s.Stager.DepDir(), "bin", "nginx"), "-t", "-c", nginxConfPath, "-p", tmpConfDir)
-	cmd.Dir = tmpConfDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = nginxErr
-	if s.Config.Dist == "openresty" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("LD_LIBRARY_PATH=%s", filepath.Join(s.Stager.DepDir(), "nginx", "luajit", "lib")))
-	}
-	if err := s.Command.Run(cmd); err != nil {
-		_, _ = fmt.Fprint(os.Stderr, nginxErr.String())
-		return fmt.Errorf("nginx.conf contains syntax errors: %s", err.Error())
+		cmd = exec.Command(filepath.Join(s.Stager.DepDir(), "bin", "nginx"), "-t", "-c", nginxConfPath, "-p", tmpConfDir)
+		cmd.Dir = tmpConfDir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = nginxErr
+		if s.Config.Dist == "openresty" {
+			cmd.Env = append(os.Environ(), fmt.Sprintf("LD_LIBRARY_PATH=%s", filepath.Join(s.Stager.DepDir(), "nginx", "luajit", "lib")))
+		}
+		err := s.Command.Run(cmd)
+		if err == nil {
+			break
+		}
+
+		errs := parseNginxErrors(nginxErr.String(), tmpConfDir, readLineMap(lineMapPath))
+		if len(errs) > 0 && len(deferred) < maxDeferredHosts {
+			if host, ok := deferUnresolvedHost(tmpConfDir, errs[0]); ok {
+				deferred = append(deferred, launcher.DeferredHost{Host: host, Location: fmt.Sprintf("%s:%d", filepath.Join(relDir, errs[0].File), errs[0].Line)})
+				continue
+			}
+		}
+
+		if len(errs) == 0 {
+			_, _ = fmt.Fprint(os.Stderr, nginxErr.String())
+			return nil, fmt.Errorf("nginx.conf contains syntax errors: %s", err.Error())
+		}
+
+		for i, e := range errs {
+			if e.File != "" && !filepath.IsAbs(e.File) {
+				snippet := sourceSnippet(filepath.Join(filepath.Dir(confPath), e.File), e.Line)
+				errs[i].File = filepath.Join(relDir, e.File)
+				s.Log.Error("%s", errs[i])
+				if snippet != "" {
+					s.Log.Info("%s", snippet)
+				}
+			} else {
+				s.Log.Error("%s", e)
+			}
+		}
+		return nil, fmt.Errorf("nginx.conf contains syntax errors: %s", errs[0])
 	}
 
-	return nil
+	for _, host := range deferred {
+		s.Log.Warning("Warning: %s: %s does not resolve while staging, so nginx -t checked the config with 127.0.0.1 in its place. It is checked again when the app starts.", host.Location, host.Host)
+	}
+	return deferred, nil
 }
 
 func (s *Supplier) availableVersions() []string {
@@ -380,16 +742,3 @@ func (s *Supplier) isStableLine(version string) bool {
 	_, err := libbuildpack.FindMatchingVersion(stableLine, []string{version})
 	return err == nil
 }
-
-func GetIncludedConfs(str string) []string {
-	includeFiles := []string{}
-	includeRe := regexp.MustCompile(`include\s+([-.\w\/]+\.conf);`)
-
-	matches := includeRe.FindAllStringSubmatch(str, -1)
-	for _, v := range matches {
-		if len(v) == 2 {
-			includeFiles = append(includeFiles, v[1])
-		}
-	}
-	return includeFiles
-}
@@ -2,12 +2,17 @@ package supply_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	exec "os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/launcher"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
 	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
 	"github.com/golang/mock/gomock"
 
@@ -17,6 +22,28 @@ import (
 
 //go:generate mockgen -source=supply.go --destination=mocks_test.go --package=supply_test
 
+// renderPort stands in for varify in the port check and replaces {{port}}
+// in the copied config files with the PORT the command was given.
+func renderPort(c *exec.Cmd) ([]byte, error) {
+	port := ""
+	for _, env := range c.Env {
+		if strings.HasPrefix(env, "PORT=") {
+			port = strings.TrimPrefix(env, "PORT=")
+		}
+	}
+
+	return nil, filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(strings.ReplaceAll(string(contents), "{{port}}", port)), info.Mode())
+	})
+}
+
 var _ = Describe("Supply", func() {
 	var (
 		depDir        string
@@ -111,6 +138,15 @@ var _ = Describe("Supply", func() {
 				Expect(supplier.InstallNGINX()).To(Succeed())
 				Expect(buffer).To(ContainSubstring(`Requested nginx version: 1.12.x => 1.12.3`))
 			})
+
+			It("records the request, version and version line in the report", func() {
+				mockInstaller.EXPECT().InstallDependency(gomock.Any(), gomock.Any())
+				Expect(supplier.InstallNGINX()).To(Succeed())
+				Expect(supplier.Report.Dist).To(Equal("nginx"))
+				Expect(supplier.Report.RequestedVersion).To(Equal("1.12.x"))
+				Expect(supplier.Report.Version).To(Equal("1.12.3"))
+				Expect(supplier.Report.VersionLine).To(Equal("stable"))
+			})
 		})
 
 		Context("request specific version", func() {
@@ -125,6 +161,34 @@ var _ = Describe("Supply", func() {
 			})
 		})
 
+		Context("with nginx.modules", func() {
+			BeforeEach(func() {
+				GinkgoT().Setenv("CF_STACK", "cflinuxfs4")
+				supplier.Config.Nginx.Version = "1.12.3"
+				supplier.Config.Nginx.Modules = []string{"brotli", "njs"}
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "nginx", Version: "1.12.3"}, gomock.Any())
+				mockManifest.EXPECT().AllDependencyVersions("nginx-module-brotli").Return([]string{"1.12.3", "1.13.8"}).AnyTimes()
+			})
+
+			It("installs the modules built for the nginx version into the global modules dir", func() {
+				mockManifest.EXPECT().AllDependencyVersions("nginx-module-njs").Return([]string{"1.12.3"})
+				modulesDir := filepath.Join(depDir, "nginx", "modules")
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "nginx-module-brotli", Version: "1.12.3"}, modulesDir)
+				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "nginx-module-njs", Version: "1.12.3"}, modulesDir)
+				Expect(supplier.InstallNGINX()).To(Succeed())
+			})
+
+			It("fails with the nginx versions that a module is built for", func() {
+				mockManifest.EXPECT().AllDependencyVersions("nginx-module-njs").Return([]string{"1.12.2", "1.13.8"})
+				Expect(supplier.InstallNGINX()).To(MatchError("the module njs in nginx.modules is not built for nginx 1.12.3 on cflinuxfs4, set nginx.version to one it is built for: 1.12.2, 1.13.8"))
+			})
+
+			It("fails when a module is not in the manifest", func() {
+				mockManifest.EXPECT().AllDependencyVersions("nginx-module-njs").Return(nil)
+				Expect(supplier.InstallNGINX()).To(MatchError("the module njs in nginx.modules is not available on cflinuxfs4: the buildpack's manifest has no nginx-module-njs dependency, put a build of the module in the app's modules directory instead"))
+			})
+		})
+
 		Describe("warns if 'stable' line is chosen", func() {
 			const warning = `Warning: usage of "stable" versions of NGINX is discouraged in most cases by the NGINX team.`
 
@@ -173,6 +237,336 @@ var _ = Describe("Supply", func() {
 		})
 	})
 
+	Describe("Setup", func() {
+		var buildDir, depsDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, buildDir)
+			depsDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, depsDir)
+			rootDir, err := os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, rootDir)
+
+			Expect(os.WriteFile(filepath.Join(rootDir, "manifest.yml"), []byte("version_lines:\n  mainline: 1.13.x\n"), 0644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(depsDir, "1"), 0755)).To(Succeed())
+			mockStager.EXPECT().BuildDir().Return(buildDir).AnyTimes()
+			mockStager.EXPECT().DepsDir().Return(depsDir).AnyTimes()
+			mockManifest.EXPECT().RootDir().Return(rootDir).AnyTimes()
+		})
+
+		Context("nginx is the final buildpack", func() {
+			BeforeEach(func() {
+				mockStager.EXPECT().DepsIdx().Return("1").AnyTimes()
+			})
+
+			It("prepares the app to be served by nginx", func() {
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.SupplyOnly).To(BeFalse())
+				Expect(supplier.VersionLines).To(Equal(map[string]string{"mainline": "1.13.x"}))
+				Expect(filepath.Join(buildDir, "logs")).To(BeADirectory())
+			})
+
+			It("reports the Staticfile settings that are not translated", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile"), []byte("pushstate: enabled\nstatus_codes:\n  404: /404.html\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.Config.Nginx.Site.SPA).To(BeTrue())
+				Expect(buffer.String()).To(ContainSubstring("Staticfile: status_codes is not supported"))
+				Expect(buffer.String()).To(ContainSubstring("Staticfile: root is not set and there is no public dir, so the app dir is served without /Staticfile, "))
+			})
+
+			It("rejects variants that are not a plain name", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  variants: [production, ../other]\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(MatchError(`invalid variant "../other" in nginx.variants, expected a name such as production`))
+			})
+
+			It("rejects invalid module names", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  modules: [brotli, ../evil]\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(MatchError(`invalid nginx.modules[1] "../evil", expected a name such as headers-more`))
+			})
+
+			It("prefers nginx.site over a Staticfile", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile"), []byte("pushstate: enabled\n"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  site:\n    root: www\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.Staticfile).To(BeNil())
+				Expect(supplier.Config.Nginx.Site.Root).To(Equal("www"))
+			})
+
+			It("cannot run nginx as a sidecar", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  sidecar:\n    app_port: 9000\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(MatchError("nginx.sidecar needs a buildpack after nginx to stage the app"))
+			})
+
+			It("only supplies nginx if buildpack.yml says so", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  supply_only: true\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.SupplyOnly).To(BeTrue())
+			})
+		})
+
+		Context("a buildpack comes after nginx", func() {
+			BeforeEach(func() {
+				mockStager.EXPECT().DepsIdx().Return("0").AnyTimes()
+			})
+
+			It("only supplies nginx, even if the app already has a logs dir", func() {
+				Expect(os.Mkdir(filepath.Join(buildDir, "logs"), 0755)).To(Succeed())
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.SupplyOnly).To(BeTrue())
+				Expect(supplier.Report.SupplyOnly).To(BeTrue())
+				Expect(buffer.String()).To(ContainSubstring("nginx is not the final buildpack"))
+			})
+
+			It("runs nginx as a sidecar if buildpack.yml says so", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  sidecar: true\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.SupplyOnly).To(BeTrue())
+				Expect(supplier.Config.Nginx.Sidecar.Enabled).To(BeTrue())
+				Expect(supplier.Report.Sidecar).To(BeTrue())
+			})
+
+			It("does not let the app take the port that nginx listens on", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  sidecar:\n    app_port_env: PORT\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(MatchError("nginx.sidecar.app_port_env cannot be PORT, which nginx listens on"))
+			})
+
+			It("rejects a config path outside the app", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  config_path: ../nginx.conf\n  supply_only: false\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(MatchError(`nginx.config_path "../nginx.conf" must be inside the app`))
+			})
+
+			It("serves the app if buildpack.yml says so", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("nginx:\n  supply_only: false\n"), 0644)).To(Succeed())
+				Expect(supplier.Setup()).To(Succeed())
+				Expect(supplier.SupplyOnly).To(BeFalse())
+				Expect(filepath.Join(buildDir, "logs")).To(BeADirectory())
+			})
+		})
+	})
+
+	Describe("InstallSidecar", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, buildDir)
+			mockStager.EXPECT().BuildDir().Return(buildDir).AnyTimes()
+			mockStager.EXPECT().DepsIdx().Return("0").AnyTimes()
+			supplier.Config.Nginx.Sidecar.Enabled = true
+		})
+
+		It("proxies to the app with the default config", func() {
+			mockStager.EXPECT().WriteProfileD("nginx-sidecar", "export NGINX_APP_PORT=8081\nexport APP_PORT=8081\n")
+			Expect(supplier.InstallSidecar()).To(Succeed())
+
+			conf, err := os.ReadFile(filepath.Join(depDir, "nginx", "sidecar", "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(conf)).To(ContainSubstring(`proxy_pass http://127.0.0.1:{{env "NGINX_APP_PORT"}};`))
+
+			launch, err := os.ReadFile(filepath.Join(depDir, "launch.yml"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(launch).To(MatchYAML(`processes:
+- type: nginx
+  command: $DEPS_DIR/0/bin/launcher -varify $DEPS_DIR/0/bin/varify -nginx $DEPS_DIR/0/bin/nginx -buildpack-yml-path $HOME/buildpack.yml -conf $DEPS_DIR/0/nginx/sidecar/nginx.conf -output-dir /tmp/nginx-sidecar -local-modules $HOME/modules -global-modules $DEPS_DIR/0/nginx/modules -generated-includes $DEPS_DIR/0/nginx/generated-includes.conf -deferred-hosts $DEPS_DIR/0/nginx/deferred-hosts.json
+  platforms:
+    cloudfoundry:
+      sidecar_for: [web]
+`))
+
+			Expect(supplier.Lint()).To(Succeed())
+			Expect(buffer.String()).NotTo(ContainSubstring("Warning"))
+		})
+
+		It("runs with the app's template and tells the app its port", func() {
+			supplier.Config.Nginx.Sidecar.AppPort = 9000
+			supplier.Config.Nginx.Sidecar.AppPortEnv = "SERVER_PORT"
+			supplier.Config.Nginx.Sidecar.Conf = "nginx/sidecar.conf"
+			mockStager.EXPECT().WriteProfileD("nginx-sidecar", "export NGINX_APP_PORT=9000\nexport SERVER_PORT=9000\n")
+			Expect(supplier.InstallSidecar()).To(Succeed())
+
+			Expect(filepath.Join(depDir, "nginx", "sidecar")).NotTo(BeADirectory())
+			launch, err := os.ReadFile(filepath.Join(depDir, "launch.yml"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(launch)).To(ContainSubstring(" -conf $HOME/nginx/sidecar.conf "))
+		})
+	})
+
+	Describe("GenerateConf", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, buildDir)
+			mockStager.EXPECT().BuildDir().Return(buildDir).AnyTimes()
+		})
+
+		It("does not generate nginx.conf without nginx.site", func() {
+			Expect(supplier.GeneratesConf()).To(BeFalse())
+		})
+
+		It("does not replace the app's own nginx.conf", func() {
+			supplier.Config.Nginx.Site = &site.Config{SPA: true}
+			Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), nil, 0644)).To(Succeed())
+			Expect(supplier.GeneratesConf()).To(BeFalse())
+		})
+
+		It("generates nginx.conf at nginx.config_path from nginx.site", func() {
+			supplier.Config.Nginx.Site = &site.Config{SPA: true, Gzip: true}
+			supplier.Config.Nginx.ConfigPath = "deploy/nginx.conf"
+			Expect(supplier.GeneratesConf()).To(BeTrue())
+
+			Expect(supplier.GenerateConf()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Generating deploy/nginx.conf from nginx.site in buildpack.yml"))
+			contents, err := os.ReadFile(filepath.Join(buildDir, "deploy", "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring("try_files $uri $uri/ /index.html;"))
+
+			Expect(supplier.Lint()).To(Succeed())
+			Expect(buffer.String()).NotTo(ContainSubstring("Warning"))
+		})
+
+		It("generates nginx.conf from a Staticfile", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "Staticfile"), []byte("root: dist\npushstate: enabled\n"), 0644)).To(Succeed())
+			staticfile, err := site.ReadStaticfile(buildDir)
+			Expect(err).NotTo(HaveOccurred())
+			supplier.Staticfile = staticfile
+			supplier.Config.Nginx.Site = &staticfile.Config
+			Expect(supplier.GeneratesConf()).To(BeTrue())
+
+			Expect(supplier.GenerateConf()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Generating nginx.conf from Staticfile"))
+			contents, err := os.ReadFile(filepath.Join(buildDir, "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring("root dist;"))
+		})
+
+	})
+
+	Describe("CheckLocalModules", func() {
+		const signature = "8,4,8,0000111111010111001110111111000110"
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, buildDir)
+			mockStager.EXPECT().BuildDir().Return(buildDir).AnyTimes()
+
+			Expect(os.MkdirAll(filepath.Join(buildDir, "modules"), 0755)).To(Succeed())
+			Expect(libbuildpack.CopyFile(filepath.Join("..", "abi", "testdata", "ngx_http_test_module.so"), filepath.Join(buildDir, "modules", "ngx_http_test_module.so"))).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depDir, "bin"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depDir, "bin", "nginx"), []byte("\x7fELF\x00"+signature+"\x00"), 0755)).To(Succeed())
+		})
+
+		It("accepts modules built for the installed nginx", func() {
+			supplier.Report.Version = "1.25.3"
+			Expect(supplier.CheckLocalModules()).To(Succeed())
+		})
+
+		It("fails on a module built for another nginx version", func() {
+			supplier.Report.Version = "1.29.8"
+			Expect(supplier.CheckLocalModules()).To(MatchError("nginx 1.29.8 cannot load modules/ngx_http_test_module.so (ngx_http_test_module): module is not binary compatible: built for nginx 1.25.3, not 1.29.8; rebuild it against nginx 1.29.8 or set nginx.version to 1.25.3"))
+		})
+
+		It("fails on a module built with other configure flags", func() {
+			supplier.Report.Version = "1.25.3"
+			Expect(os.WriteFile(filepath.Join(depDir, "bin", "nginx"), []byte("\x7fELF\x008,4,8,0000111111010111001111111111000110\x00"), 0755)).To(Succeed())
+			Expect(supplier.CheckLocalModules()).To(MatchError(ContainSubstring("built with other configure flags than nginx 1.25.3")))
+		})
+	})
+
+	Describe("GenerateIncludes", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, buildDir)
+			mockStager.EXPECT().BuildDir().Return(buildDir).AnyTimes()
+		})
+
+		It("does nothing without _redirects and _headers", func() {
+			Expect(supplier.GenerateIncludes()).To(Succeed())
+			Expect(filepath.Join(depDir, "nginx", "generated-includes.conf")).NotTo(BeAnExistingFile())
+		})
+
+		It("generates the rules from the root of nginx.site and reports the ones it leaves out", func() {
+			supplier.Config.Nginx.Site = &site.Config{Root: "dist"}
+			Expect(os.MkdirAll(filepath.Join(buildDir, "dist"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "dist", "_redirects"), []byte("/old /new\n/api/* https://api.example.com/:splat 200\n"), 0644)).To(Succeed())
+
+			Expect(supplier.GenerateIncludes()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("_redirects:2 is left out: proxying to https://api.example.com/:splat is not supported"))
+			contents, err := os.ReadFile(filepath.Join(depDir, "nginx", "generated-includes.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring("return 301 /new$is_args$args;"))
+		})
+	})
+
+	Describe("WriteReport", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = os.MkdirTemp("", "")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, buildDir)
+			mockStager.EXPECT().BuildDir().Return(buildDir).AnyTimes()
+
+			Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("daemon off;\nerror_log logs/error.log;\nhttp {\n  include sites/*.conf;\n}\n"), 0666)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "sites"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "sites", "app.conf"), []byte("server { listen {{port}}; }"), 0666)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "modules"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "modules", "ngx_http_headers_more_filter_module.so"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "modules", "ngx_stream_module.so"), nil, 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depDir, "nginx", "modules"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depDir, "nginx", "modules", "ngx_stream_module.so"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depDir, "nginx", "modules", "ngx_mail_module.so"), nil, 0644)).To(Succeed())
+		})
+
+		It("writes what was staged to the staging report", func() {
+			supplier.Config.Nginx.PlaintextEnvVars = []string{"APP_NAME"}
+			supplier.Report = supply.Report{Dist: "nginx", RequestedVersion: "mainline", Version: "1.13.8", VersionLine: "mainline"}
+			Expect(supplier.Lint()).To(Succeed())
+			Expect(supplier.WriteReport()).To(Succeed())
+
+			contents, err := os.ReadFile(filepath.Join(depDir, "nginx", "staging-report.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contents).To(MatchJSON(`{
+				"supply_only": false,
+				"sidecar": false,
+				"dist": "nginx",
+				"requested_version": "mainline",
+				"version": "1.13.8",
+				"version_line": "mainline",
+				"modules": [
+					{"name": "ngx_http_headers_more_filter_module", "source": "local", "path": "modules/ngx_http_headers_more_filter_module.so"},
+					{"name": "ngx_mail_module", "source": "global", "path": "nginx/modules/ngx_mail_module.so"},
+					{"name": "ngx_stream_module", "source": "local", "path": "modules/ngx_stream_module.so"}
+				],
+				"confs": ["nginx.conf", "sites/app.conf"],
+				"variants": [],
+				"lint": [
+					{"rule": "access-log", "severity": "warning", "message": "access logging is turned off in your nginx.conf file, this may make your app difficult to debug."},
+					{"file": "nginx.conf", "line": 2, "rule": "error-log-stderr", "severity": "warning", "message": "error_log writes to the file logs/error.log, which is not part of the app logs"}
+				],
+				"plaintext_env_vars": ["APP_NAME"]
+			}`))
+		})
+	})
+
 	Describe("WriteProfileD", func() {
 		It("writes nginx script", func() {
 			mockStager.EXPECT().DepsIdx().Return("0")
@@ -222,7 +616,9 @@ var _ = Describe("Supply", func() {
 				Return([]byte{}, nil).
 				Do(func(c *exec.Cmd) {
 					Expect(c.Path).To(Equal(filepath.Join(depDir, "bin", "varify")))
+					Expect(c.Args[1]).To(Equal("render"))
 					Expect(filepath.Base(c.Args[3])).To(Equal("nginx.conf"))
+					Expect(c.Args).To(ContainElement("-validation"))
 				})
 			// No point checking the ret val of this here since the real work is done
 			// by the varify executable
@@ -232,7 +628,7 @@ var _ = Describe("Supply", func() {
 		Context("app's nginx.conf is missing {{port}}", func() {
 			It("logs error stating {{port}} is missing", func() {
 				nginxConfPath := filepath.Join(buildDir, "nginx.conf")
-				err := os.WriteFile(nginxConfPath, []byte("FOOBAR"), 0666)
+				err := os.WriteFile(nginxConfPath, []byte("FOOBAR;"), 0666)
 				Expect(err).NotTo(HaveOccurred())
 				mockCommand.EXPECT().RunWithOutput(gomock.Any())
 				err = supplier.ValidateNginxConf()
@@ -242,44 +638,269 @@ var _ = Describe("Supply", func() {
 			})
 		})
 
+		Context("app's nginx.conf only mentions {{port}} in a comment", func() {
+			It("logs error stating {{port}} is missing", func() {
+				nginxConfPath := filepath.Join(buildDir, "nginx.conf")
+				err := os.WriteFile(nginxConfPath, []byte("http { server { # listen {{port}};\n listen 8080; } }"), 0666)
+				Expect(err).NotTo(HaveOccurred())
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(renderPort)
+				err = supplier.ValidateNginxConf()
+				Expect(err).Should(MatchError("validation of port `{{port}}` failed: no `{{port}}` in nginx.conf"))
+			})
+		})
+
+		Context("app's nginx.conf listens on {{port}} in an included file", func() {
+			It("succeeds", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("http { include sites/*.conf; }"), 0666)).To(Succeed())
+				Expect(os.Mkdir(filepath.Join(buildDir, "sites"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "sites", "app.conf"), []byte("server { listen 127.0.0.1:{{port}}; }"), 0666)).To(Succeed())
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(renderPort)
+				mockCommand.EXPECT().Run(gomock.Any()).Return(errors.New("stop after the port check"))
+				err := supplier.ValidateNginxConf()
+				Expect(err).To(MatchError(ContainSubstring("validation of nginx conf syntax failed")))
+			})
+		})
+
+		Context("nginx rejects the rendered config", func() {
+			BeforeEach(func() {
+				src := "daemon off;\n{{module \"ngx_stream_module\"}}\nhttp {\n  server {\n    listen {{port}};\n    lisen 8081;\n  }\n}\n"
+				Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte(src), 0666)).To(Succeed())
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(renderPort)
+				mockCommand.EXPECT().Run(gomock.Any()).Times(2).DoAndReturn(func(c *exec.Cmd) error {
+					if filepath.Base(c.Path) == "varify" {
+						// the module expands to two lines
+						Expect(c.Args).To(ContainElement("-validation"))
+						lineMapPath := ""
+						for i, arg := range c.Args {
+							if arg == "-line-map" {
+								lineMapPath = c.Args[i+1]
+							}
+						}
+						return os.WriteFile(lineMapPath, []byte(`{"nginx.conf": [1, 2, 2, 3, 4, 5, 6, 7, 8]}`), 0644)
+					}
+					fmt.Fprintf(c.Stderr, "nginx: [emerg] unknown directive \"lisen\" in %s/nginx.conf:7\n", c.Dir)
+					fmt.Fprintf(c.Stderr, "nginx: configuration file %s/nginx.conf test failed\n", c.Dir)
+					return errors.New("exit status 1")
+				})
+			})
+
+			It("reports the error at the template line with a snippet", func() {
+				err := supplier.ValidateNginxConf()
+				Expect(err).To(MatchError(`validation of nginx conf syntax failed: nginx.conf contains syntax errors: nginx.conf:6: unknown directive "lisen"`))
+				Expect(buffer.String()).To(ContainSubstring(`nginx.conf:6: unknown directive "lisen"`))
+				Expect(buffer.String()).To(ContainSubstring("     5 |     listen {{port}};\n"))
+				Expect(buffer.String()).To(ContainSubstring(">    6 |     lisen 8081;\n"))
+				Expect(buffer.String()).NotTo(ContainSubstring("test failed"))
+			})
+		})
+
+		Context("nginx.conf is at nginx.config_path and has variants", func() {
+			var confDir string
+
+			BeforeEach(func() {
+				supplier.Config.Nginx.ConfigPath = "deploy/nginx/nginx.conf"
+				confDir = filepath.Join(buildDir, "deploy", "nginx")
+				Expect(os.MkdirAll(confDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(confDir, "nginx.conf"), []byte("daemon off;\nhttp { server { listen {{port}}; } }\n"), 0666)).To(Succeed())
+			})
+
+			It("validates every variant", func() {
+				supplier.Config.Nginx.Variants = []string{"production"}
+				Expect(os.WriteFile(filepath.Join(confDir, "nginx.production.conf"), []byte("daemon off;\nhttp { server { listen 8080; } }\n"), 0666)).To(Succeed())
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).Times(2).DoAndReturn(renderPort)
+				mockCommand.EXPECT().Run(gomock.Any()).Times(2)
+
+				err := supplier.ValidateNginxConf()
+				Expect(err).To(MatchError("validation of port `{{port}}` failed: no `{{port}}` in deploy/nginx/nginx.production.conf"))
+				Expect(buffer.String()).To(ContainSubstring("Validating deploy/nginx/nginx.conf"))
+				Expect(buffer.String()).To(ContainSubstring("The listen port value in deploy/nginx/nginx.production.conf must be configured"))
+			})
+
+			It("only validates the variants in nginx.variants, not files that nginx.conf includes", func() {
+				Expect(os.WriteFile(filepath.Join(confDir, "nginx.conf"), []byte("daemon off;\nhttp { server { listen {{port}}; include nginx.ssl.conf; } }\n"), 0666)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(confDir, "nginx.ssl.conf"), []byte("ssl_protocols TLSv1.2 TLSv1.3;\n"), 0666)).To(Succeed())
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(renderPort)
+				mockCommand.EXPECT().Run(gomock.Any()).Times(2)
+
+				Expect(supplier.ValidateNginxConf()).To(Succeed())
+				Expect(buffer.String()).NotTo(ContainSubstring("Validating"))
+			})
+
+			It("fails when a variant in nginx.variants does not exist", func() {
+				supplier.Config.Nginx.Variants = []string{"staging"}
+
+				err := supplier.ValidateNginxConf()
+				Expect(err).To(MatchError("nginx.variants lists staging, but deploy/nginx/nginx.staging.conf does not exist"))
+			})
+
+			It("names the template in nginx errors relative to the app", func() {
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(renderPort)
+				mockCommand.EXPECT().Run(gomock.Any()).Times(2).DoAndReturn(func(c *exec.Cmd) error {
+					if filepath.Base(c.Path) == "varify" {
+						Expect(c.Args).To(ContainElement(HaveSuffix("/nginx.conf")))
+						return nil
+					}
+					fmt.Fprintf(c.Stderr, "nginx: [emerg] unknown directive \"lisen\" in %s/nginx.conf:2\n", c.Dir)
+					return errors.New("exit status 1")
+				})
+
+				err := supplier.ValidateNginxConf()
+				Expect(err).To(MatchError(`validation of nginx conf syntax failed: nginx.conf contains syntax errors: deploy/nginx/nginx.conf:2: unknown directive "lisen"`))
+				Expect(buffer.String()).To(ContainSubstring(">    2 | http { server { listen {{port}}; } }"))
+			})
+		})
+
+		Context("upstream hosts do not resolve while staging", func() {
+			It("checks the config with the hosts replaced and records them for the launcher", func() {
+				src := "daemon off;\nhttp {\n  upstream backend {\n    server backend.apps.internal:8080;\n  }\n  server {\n    listen {{port}};\n    location / { proxy_pass http://api.apps.internal; }\n  }\n}\n"
+				Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte(src), 0666)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depDir, "nginx"), 0755)).To(Succeed())
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(renderPort)
+
+				nginxRuns := 0
+				mockCommand.EXPECT().Run(gomock.Any()).Times(4).DoAndReturn(func(c *exec.Cmd) error {
+					if filepath.Base(c.Path) == "varify" {
+						_, err := renderPort(c)
+						return err
+					}
+
+					nginxRuns++
+					contents, err := os.ReadFile(filepath.Join(c.Dir, "nginx.conf"))
+					Expect(err).NotTo(HaveOccurred())
+					switch nginxRuns {
+					case 1:
+						fmt.Fprintf(c.Stderr, "nginx: [emerg] host not found in upstream \"backend.apps.internal:8080\" in %s/nginx.conf:4\n", c.Dir)
+					case 2:
+						Expect(string(contents)).To(ContainSubstring("server 127.0.0.1:8080;"))
+						fmt.Fprintf(c.Stderr, "nginx: [emerg] host not found in upstream \"api.apps.internal\" in %s/nginx.conf:8\n", c.Dir)
+					default:
+						Expect(string(contents)).To(ContainSubstring("proxy_pass http://127.0.0.1;"))
+						return nil
+					}
+					return errors.New("exit status 1")
+				})
+
+				Expect(supplier.ValidateNginxConf()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Warning: nginx.conf:4: backend.apps.internal does not resolve while staging"))
+				Expect(buffer.String()).To(ContainSubstring("Warning: nginx.conf:8: api.apps.internal does not resolve while staging"))
+
+				contents, err := os.ReadFile(filepath.Join(buildDir, "nginx.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(src))
+
+				hosts, err := launcher.ReadDeferredHosts(filepath.Join(depDir, "nginx", "deferred-hosts.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hosts).To(Equal([]launcher.DeferredHost{
+					{Host: "backend.apps.internal", Location: "nginx.conf:4"},
+					{Host: "api.apps.internal", Location: "nginx.conf:8"},
+				}))
+			})
+		})
+
 		Context("CheckAccessLogging", func() {
 			BeforeEach(func() {
 				mockCommand.EXPECT().Run(gomock.Any()).AnyTimes()
 			})
 
 			It("logs a warning when access logging is not set", func() {
-				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("some content"), 0666)
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("some content;"), 0666)
 				Expect(supplier.CheckAccessLogging()).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
 			})
 
 			It("logs a warning when access logging is set to off", func() {
-				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log off"), 0666)
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log off;"), 0666)
 				Expect(supplier.CheckAccessLogging()).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
 			})
 
 			It("logs a warning when access logging is set to off with extra spaces", func() {
-				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log    off"), 0666)
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log    off;"), 0666)
 				Expect(supplier.CheckAccessLogging()).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
 			})
 
 			It("logs a warning when access logging is set to OFF", func() {
-				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log OFF"), 0666)
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log OFF;"), 0666)
 				Expect(supplier.CheckAccessLogging()).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
 			})
 
 			It("logs a warning when access logging is set to a path", func() {
-				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log /some/path"), 0666)
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log /some/path;"), 0666)
 				Expect(supplier.CheckAccessLogging()).To(Succeed())
 				Expect(buffer.String()).ToNot(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
 			})
+
+			It("does not count a commented out access_log off", func() {
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("# access_log off;\naccess_log /some/path;"), 0666)
+				Expect(supplier.CheckAccessLogging()).To(Succeed())
+				Expect(buffer.String()).ToNot(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
+			})
+
+			It("finds access_log directives in included files", func() {
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("http { include site.conf; }"), 0666)
+				os.WriteFile(filepath.Join(buildDir, "site.conf"), []byte("access_log /some/path;"), 0666)
+				Expect(supplier.CheckAccessLogging()).To(Succeed())
+				Expect(buffer.String()).ToNot(ContainSubstring("Warning: access logging is turned off in your nginx.conf file, this may make your app difficult to debug."))
+			})
+
+			It("returns syntax errors", func() {
+				os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("access_log /some/path"), 0666)
+				Expect(supplier.CheckAccessLogging()).To(MatchError(ContainSubstring(`unexpected end of file, expecting ";" or "}" after "access_log"`)))
+			})
+		})
+
+		Context("Lint", func() {
+			const conf = "daemon off;\nerror_log stderr;\nhttp { access_log /dev/stdout; include site.conf; }\n"
+
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte(conf), 0666)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "site.conf"), []byte("server {\n  listen {{port}};\n}\n"), 0666)).To(Succeed())
+			})
+
+			It("passes a config that suits a container", func() {
+				Expect(supplier.Lint()).To(Succeed())
+				Expect(buffer.String()).To(BeEmpty())
+			})
+
+			It("logs warnings with their location and a fix", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "site.conf"), []byte("server {\n  listen 80;\n}\n"), 0666)).To(Succeed())
+				Expect(supplier.Lint()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Warning: site.conf:2: listen 80 uses port 80, which the app is not allowed to bind [privileged-port]"))
+				Expect(buffer.String()).To(ContainSubstring("Listen on `{{port}}` instead."))
+			})
+
+			It("fails on errors", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "nginx.conf"), []byte("http { access_log /dev/stdout; root "+filepath.Join(buildDir, "public")+"; }"), 0666)).To(Succeed())
+				Expect(supplier.Lint()).To(MatchError("nginx.conf has lint errors, see above"))
+				Expect(buffer.String()).To(ContainSubstring("`daemon off;` is missing"))
+				Expect(buffer.String()).To(ContainSubstring("nginx.conf:1: " + filepath.Join(buildDir, "public") + " points into " + buildDir + ", which only exists while staging [staging-path]"))
+			})
+
+			It("skips rules disabled in buildpack.yml", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "site.conf"), []byte("user nobody;"), 0666)).To(Succeed())
+				supplier.Config.Nginx.Lint.Disable = []string{"user-directive"}
+				Expect(supplier.Lint()).To(Succeed())
+				Expect(buffer.String()).To(BeEmpty())
+			})
+
+			It("fails on warnings in strict mode", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "site.conf"), []byte("user nobody;"), 0666)).To(Succeed())
+				supplier.Config.Nginx.Lint.Strict = true
+				Expect(supplier.Lint()).To(MatchError("nginx.conf has lint errors, see above"))
+				Expect(buffer.String()).To(ContainSubstring("site.conf:1: the user directive has no effect since nginx does not run as root [user-directive]"))
+			})
 		})
 	})
 
 	Describe("GetIncludedConfs", func() {
+		parse := func(str string) *nginxconf.Config {
+			conf, err := nginxconf.Parse("nginx.conf", []byte(str))
+			Expect(err).NotTo(HaveOccurred())
+			return conf
+		}
+
 		const nginxConfStr = `
 http {
   include    conf/mime.types;
@@ -294,13 +915,112 @@ http {
     access_log   logs/domain1.access.log  main;
     root         html;
 	}
+}
 `
 		It("extracts included conf files", func() {
-			includeFiles := supply.GetIncludedConfs(nginxConfStr)
-			Expect(includeFiles).To(Equal([]string{"/etc/nginx/proxy.conf",
+			includeFiles := supply.GetIncludedConfs(parse(nginxConfStr))
+			Expect(includeFiles).To(Equal([]string{"conf/mime.types",
+				"/etc/nginx/proxy.conf",
 				"custom.conf",
 				"/etc/nginx/fastcgi.conf",
 			}))
 		})
+
+		It("extracts quoted and glob paths", func() {
+			includeFiles := supply.GetIncludedConfs(parse(`include "conf.d/my site.conf"; include 'snippets/*';include conf.d/*.conf;`))
+			Expect(includeFiles).To(Equal([]string{"conf.d/my site.conf", "snippets/*", "conf.d/*.conf"}))
+		})
+
+		It("ignores commented out includes", func() {
+			includeFiles := supply.GetIncludedConfs(parse("# include old.conf;\ninclude new.conf; # include other.conf;\n"))
+			Expect(includeFiles).To(Equal([]string{"new.conf"}))
+		})
+	})
+
+	Describe("ResolveIncludes", func() {
+		var confDir string
+
+		writeConf := func(name, contents string) {
+			path := filepath.Join(confDir, name)
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			confDir, err = os.MkdirTemp("", "nginx.confdir")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, confDir)
+		})
+
+		It("walks nested includes relative to the config directory", func() {
+			writeConf("nginx.conf", "http { include mime.types; include conf.d/*.conf; }")
+			writeConf("mime.types", "types {}")
+			writeConf("conf.d/b.conf", "server { include snippets/common; }")
+			writeConf("conf.d/a.conf", "server {}")
+			writeConf("conf.d/.hidden.conf", "server {}")
+			writeConf("conf.d/readme.txt", "")
+			writeConf("snippets/common", "listen {{port}};")
+
+			files, err := supply.ResolveIncludes(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{
+				filepath.Join(confDir, "mime.types"),
+				filepath.Join(confDir, "conf.d", "a.conf"),
+				filepath.Join(confDir, "conf.d", "b.conf"),
+				filepath.Join(confDir, "snippets", "common"),
+			}))
+		})
+
+		It("lists files included more than once only once", func() {
+			writeConf("nginx.conf", "include a.conf; include b.conf;")
+			writeConf("a.conf", "include common.conf;")
+			writeConf("b.conf", "include common.conf;")
+			writeConf("common.conf", "")
+
+			files, err := supply.ResolveIncludes(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{
+				filepath.Join(confDir, "a.conf"),
+				filepath.Join(confDir, "common.conf"),
+				filepath.Join(confDir, "b.conf"),
+			}))
+		})
+
+		It("leaves syntax errors for nginx to report", func() {
+			writeConf("nginx.conf", "include a.conf;\nHi the port is {{port}}.")
+			writeConf("a.conf", "")
+
+			files, err := supply.ResolveIncludes(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{filepath.Join(confDir, "a.conf")}))
+
+			_, err = supply.ParseConfTree(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).To(MatchError(ContainSubstring(`nginx.conf:2: unexpected end of file`)))
+		})
+
+		It("allows globs that match nothing", func() {
+			writeConf("nginx.conf", "include conf.d/*.conf;")
+
+			files, err := supply.ResolveIncludes(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("fails when a plain include does not exist", func() {
+			writeConf("nginx.conf", "include missing.conf;")
+
+			_, err := supply.ResolveIncludes(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).To(MatchError(ContainSubstring("missing.conf: no such file or directory")))
+		})
+
+		It("detects include cycles", func() {
+			writeConf("nginx.conf", "include a.conf;")
+			writeConf("a.conf", "include b.conf;")
+			writeConf("b.conf", "include a.conf;")
+
+			_, err := supply.ResolveIncludes(filepath.Join(confDir, "nginx.conf"))
+			Expect(err).To(MatchError(fmt.Sprintf("include cycle detected: %[1]s/a.conf -> %[1]s/b.conf -> %[1]s/a.conf", confDir)))
+		})
 	})
 })
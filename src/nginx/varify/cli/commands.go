@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{name: "render", summary: "render nginx.conf and the files it includes", run: runRender},
+	{name: "validate", summary: "render into a temporary directory and check the result with nginx -t", run: runValidate},
+	{name: "vars", summary: "list the environment variables the templates reference", run: runVars},
+	{name: "modules", summary: "list the modules that {{module}} can load", run: runModules},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: varify <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'varify <command> -help' for the flags of a command.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Exit codes:")
+	fmt.Fprintf(w, "  %d  success\n", exitOK)
+	fmt.Fprintf(w, "  %d  rendering failed\n", exitRenderFailed)
+	fmt.Fprintf(w, "  %d  invalid usage\n", exitUsage)
+	fmt.Fprintf(w, "  %d  nginx rejected the rendered config\n", exitInvalidConfig)
+	fmt.Fprintf(w, "  %d  nginx could not be run\n", exitNginxFailed)
+}
+
+// newFlagSet returns a flag set for the named command. Parsing exits with
+// exitUsage on bad flags, and with exitOK after printing help.
+func newFlagSet(name, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet("varify "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: varify %s [flags]\n\n%s.\n\nFlags:\n", name, summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and rejects positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) bool {
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %v\n", fs.Args())
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+func runRender(args []string) int {
+	var opts renderOptions
+	fs := newFlagSet("render", "Render nginx.conf and the files it includes, in place unless -output-dir or -print is given")
+	opts.addFlags(fs)
+	opts.addValidationFlag(fs)
+	outputDir := fs.String("output-dir", "", "directory to render into instead of overwriting the config files")
+	printOnly := fs.Bool("print", false, "write the rendered config files to stdout instead of overwriting them")
+	lineMapPath := fs.String("line-map", "", "file to write a JSON map from rendered lines back to template lines to")
+	if !parseFlags(fs, args) {
+		return exitUsage
+	}
+
+	return render(opts, *outputDir, *printOnly, *lineMapPath)
+}
+
+// runLegacy supports the original interface of varify: flags followed by the
+// config file, the local and global modules directories and, optionally, the
+// resolv.conf file and the default nameserver.
+func runLegacy(args []string) int {
+	var opts renderOptions
+	fs := flag.NewFlagSet("varify", flag.ExitOnError)
+	fs.Usage = func() {
+		printUsage(fs.Output())
+	}
+	fs.StringVar(&opts.buildpackYMLPath, "buildpack-yml-path", "", "path to buildpack.yml file")
+	outputDir := fs.String("output-dir", "", "directory to render into instead of overwriting the config files")
+	printOnly := fs.Bool("print", false, "write the rendered config files to stdout instead of overwriting them")
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 3 || fs.NArg() > 5 {
+		fs.Usage()
+		return exitUsage
+	}
+	opts.confPath = fs.Arg(0)
+	opts.localModulePath = fs.Arg(1)
+	opts.globalModulePath = fs.Arg(2)
+	opts.resolvConfPath = "/etc/resolv.conf"
+	if fs.Arg(3) != "" {
+		opts.resolvConfPath = fs.Arg(3)
+	}
+	opts.defaultNameServer = "169.254.0.2"
+	if fs.Arg(4) != "" {
+		opts.defaultNameServer = fs.Arg(4)
+	}
+
+	return render(opts, *outputDir, *printOnly, "")
+}
+
+func render(opts renderOptions, outputDir string, printOnly bool, lineMapPath string) int {
+	r, err := opts.newRenderer()
+	if err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	files, err := renderTree(r, opts.confPath)
+	if err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	switch {
+	case printOnly:
+		err = printRendered(os.Stdout, r.root, files)
+	case outputDir != "":
+		err = writeToDir(r.root, outputDir, files)
+	default:
+		err = writeInPlace(files)
+	}
+	if err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	if lineMapPath != "" {
+		if err := writeLineMap(lineMapPath, r, files); err != nil {
+			log.Print(err)
+			return exitRenderFailed
+		}
+	}
+
+	return exitOK
+}
+
+func runValidate(args []string) int {
+	var opts renderOptions
+	fs := newFlagSet("validate", "Render nginx.conf into a temporary directory and check the result with nginx -t")
+	opts.addFlags(fs)
+	opts.addValidationFlag(fs)
+	nginxPath := fs.String("nginx", "nginx", "path to the nginx executable")
+	prefix := fs.String("prefix", "", "nginx prefix path (default the working directory)")
+	if !parseFlags(fs, args) {
+		return exitUsage
+	}
+
+	if *prefix == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Printf("Could not determine working directory: %s", err)
+			return exitNginxFailed
+		}
+		*prefix = wd
+	}
+
+	tmpDir, err := os.MkdirTemp("", "varify")
+	if err != nil {
+		log.Printf("Could not create temp dir: %s", err)
+		return exitRenderFailed
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outputDir := filepath.Join(tmpDir, "rendered")
+	if status := render(opts, outputDir, false, ""); status != exitOK {
+		return status
+	}
+
+	cmd := exec.Command(*nginxPath, "-t", "-p", *prefix, "-c", filepath.Join(outputDir, filepath.Base(opts.confPath)))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitInvalidConfig
+		}
+		log.Printf("Could not run nginx: %s", err)
+		return exitNginxFailed
+	}
+
+	return exitOK
+}
+
+func runVars(args []string) int {
+	var opts renderOptions
+	fs := newFlagSet("vars", "List the environment variables that nginx.conf and the files it includes reference")
+	opts.addFlags(fs)
+	if !parseFlags(fs, args) {
+		return exitUsage
+	}
+	// Load nginx.validation_env and the placeholders to report what the
+	// check while staging uses.
+	opts.validation = true
+
+	r, err := opts.newRenderer()
+	if err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	refs, err := findVariables(r, opts.confPath)
+	if err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIABLE\tFUNCTION\tSET\tSTAGING\tRUNTIME\tLOCATION")
+	for _, ref := range refs {
+		set := "no"
+		if _, ok := os.LookupEnv(ref.Name); ok {
+			set = "yes"
+		}
+		// Every variable is resolved from the environment at runtime.
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ref.Name, ref.Func, set, ref.Staging, "env", ref.Location)
+	}
+	if err := w.Flush(); err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	return exitOK
+}
+
+func runModules(args []string) int {
+	fs := newFlagSet("modules", "List the modules that {{module \"name\"}} can load, with user provided modules hiding global ones of the same name")
+	localModulePath := fs.String("local-modules", "", "path to the user provided modules directory")
+	globalModulePath := fs.String("global-modules", "", "path to the modules shipped with nginx")
+	if !parseFlags(fs, args) {
+		return exitUsage
+	}
+
+	modules, err := supply.ListModules(*localModulePath, *globalModulePath)
+	if err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tSOURCE\tPATH")
+	for _, module := range modules {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", module.Name, module.Source, module.Path)
+	}
+	if err := w.Flush(); err != nil {
+		log.Print(err)
+		return exitRenderFailed
+	}
+
+	return exitOK
+}
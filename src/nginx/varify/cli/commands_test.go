@@ -0,0 +1,220 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("varify commands", func() {
+	var tmpDir, confPath string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "nginx.tmpdir")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(os.RemoveAll, tmpDir)
+
+		confPath = filepath.Join(tmpDir, "nginx.conf")
+		Expect(os.WriteFile(confPath, []byte(`listen {{port}};`), 0644)).To(Succeed())
+	})
+
+	It("prints the commands and exit codes", func() {
+		session := runCliWithArgs([]string{"help"}, nil, 0)
+		Expect(session.Out).To(gbytes.Say(`usage: varify <command> \[flags\]`))
+		Expect(session.Out).To(gbytes.Say(`render`))
+		Expect(session.Out).To(gbytes.Say(`validate`))
+		Expect(session.Out).To(gbytes.Say(`vars`))
+		Expect(session.Out).To(gbytes.Say(`modules`))
+		Expect(session.Out).To(gbytes.Say(`Exit codes:`))
+	})
+
+	It("prints the flags of a command", func() {
+		session := runCliWithArgs([]string{"render", "-help"}, nil, 0)
+		Expect(session.Err).To(gbytes.Say(`usage: varify render \[flags\]`))
+		Expect(session.Err).To(gbytes.Say(`-conf`))
+	})
+
+	It("fails with a usage error on unknown flags", func() {
+		session := runCliWithArgs([]string{"render", "-bogus"}, nil, 2)
+		Expect(session.Err).To(gbytes.Say(`flag provided but not defined: -bogus`))
+	})
+
+	It("fails with a usage error on positional arguments", func() {
+		session := runCliWithArgs([]string{"render", confPath}, nil, 2)
+		Expect(session.Err).To(gbytes.Say(`unexpected arguments`))
+	})
+
+	It("fails with a usage error instead of panicking when legacy arguments are missing", func() {
+		session := runCliWithArgs([]string{confPath}, nil, 2)
+		Expect(session.Err).To(gbytes.Say(`usage: varify`))
+	})
+
+	Describe("render", func() {
+		It("renders the config named by -conf", func() {
+			runCliWithArgs([]string{"render", "-conf", confPath}, []string{"PORT=8080"}, 0)
+
+			contents, err := os.ReadFile(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(`listen 8080;`))
+		})
+
+		It("includes the rules generated from _redirects and _headers if there are any", func() {
+			Expect(os.WriteFile(confPath, []byte(`{{generated_includes}}`), 0644)).To(Succeed())
+			generatedPath := filepath.Join(tmpDir, "generated-includes.conf")
+			runCliWithArgs([]string{"render", "-conf", confPath, "-generated-includes", generatedPath}, nil, 0)
+
+			contents, err := os.ReadFile(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(BeEmpty())
+
+			Expect(os.WriteFile(confPath, []byte(`{{generated_includes}}`), 0644)).To(Succeed())
+			Expect(os.WriteFile(generatedPath, []byte("add_header X-Frame-Options DENY;\n"), 0644)).To(Succeed())
+			runCliWithArgs([]string{"render", "-conf", confPath, "-generated-includes", generatedPath}, nil, 0)
+
+			contents, err = os.ReadFile(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("include " + generatedPath + ";"))
+		})
+
+		Context("when rendering moves lines", func() {
+			const template = "{{/* header */}}\n{{if false}}\ndropped;\n{{end}}\n{{env \"BLOCK\"}}\nlisten {{port}};\n"
+			var bpYMLPath string
+
+			BeforeEach(func() {
+				bpYMLPath = filepath.Join(tmpDir, "buildpack.yml")
+				Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  plaintext_env_vars: [BLOCK]\n"), 0644)).To(Succeed())
+			})
+
+			It("writes a map from rendered lines back to template lines", func() {
+				Expect(os.WriteFile(confPath, []byte(template), 0644)).To(Succeed())
+				lineMapPath := filepath.Join(tmpDir, "lines.json")
+				runCliWithArgs([]string{"render", "-conf", confPath, "-buildpack-yml-path", bpYMLPath, "-line-map", lineMapPath}, []string{"PORT=8080", "BLOCK=a;\nb;"}, 0)
+
+				contents, err := os.ReadFile(confPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal("\n\na;\nb;\nlisten 8080;\n"))
+
+				contents, err = os.ReadFile(lineMapPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(MatchJSON(`{"nginx.conf": [1, 4, 5, 5, 6]}`))
+			})
+
+			It("reports errors at the template line", func() {
+				Expect(os.WriteFile(confPath, []byte(template+"set $x {{required \"NOPE\"}};\n"), 0644)).To(Succeed())
+				session := runCliWithArgs([]string{"render", "-conf", confPath, "-buildpack-yml-path", bpYMLPath}, []string{"PORT=8080", "BLOCK=a;\nb;"}, 1)
+				Expect(session.Err).To(gbytes.Say(`nginx.conf:7: required environment variable "NOPE" is not set`))
+			})
+		})
+
+		Context("with -validation", func() {
+			var bpYMLPath string
+
+			BeforeEach(func() {
+				bpYMLPath = filepath.Join(tmpDir, "buildpack.yml")
+				Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  strict: true\n  validation_env:\n    TOKEN: sample-token\n"), 0644)).To(Succeed())
+				Expect(os.WriteFile(confPath, []byte(`listen {{port}};
+server_name {{env "SERVER_NAME"}};
+proxy_pass {{required "BACKEND"}};
+proxy_read_timeout {{env "TIMEOUT"}};
+set $token {{required "TOKEN"}};
+set $greeting {{env_default "GREETING" "hello"}};
+proxy_pass http://{{env "UPSTREAM_HOST"}}:{{env "UPSTREAM_PORT"}}/;
+`), 0644)).To(Succeed())
+			})
+
+			It("fills in sample values and placeholders for runtime-only variables", func() {
+				session := runCliWithArgs([]string{"render", "-conf", confPath, "-buildpack-yml-path", bpYMLPath, "-validation", "-print"}, []string{"PORT=8080", "TOKEN=real-token"}, 0)
+				Expect(string(session.Out.Contents())).To(Equal(`# --- nginx.conf ---
+listen 8080;
+server_name placeholder.invalid;
+proxy_pass http://127.0.0.1:1;
+proxy_read_timeout 60s;
+set $token sample-token;
+set $greeting hello;
+proxy_pass http://127.0.0.1:1/;
+`))
+			})
+
+			It("leaves runtime-only variables unset without it", func() {
+				session := runCliWithArgs([]string{"render", "-conf", confPath, "-buildpack-yml-path", bpYMLPath, "-print"}, []string{"PORT=8080"}, 1)
+				Expect(session.Err).To(gbytes.Say(`nginx.conf:2: environment variable "SERVER_NAME" is not set`))
+			})
+		})
+
+		It("exits with 1 when rendering fails", func() {
+			Expect(os.WriteFile(confPath, []byte(`listen {{required "NOPE"}};`), 0644)).To(Succeed())
+			session := runCliWithArgs([]string{"render", "-conf", confPath}, nil, 1)
+			Expect(session.Err).To(gbytes.Say(`nginx.conf:1: required environment variable "NOPE" is not set`))
+		})
+	})
+
+	Describe("validate", func() {
+		var nginxPath string
+
+		writeNginx := func(body string) {
+			nginxPath = filepath.Join(tmpDir, "nginx")
+			Expect(os.WriteFile(nginxPath, []byte("#!/usr/bin/env bash\n"+body), 0755)).To(Succeed())
+		}
+
+		It("checks the rendered config with nginx -t without touching the template", func() {
+			writeNginx(`echo "$@"; cat "${@: -1}"; echo`)
+
+			session := runCliWithArgs([]string{"validate", "-conf", confPath, "-nginx", nginxPath, "-prefix", tmpDir}, []string{"PORT=8080"}, 0)
+			Expect(session.Err).To(gbytes.Say(`-t -p ` + tmpDir + ` -c /.*/rendered/nginx.conf`))
+			Expect(session.Err).To(gbytes.Say(`listen 8080;`))
+
+			contents, err := os.ReadFile(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(`listen {{port}};`))
+		})
+
+		It("exits with 3 when nginx rejects the config", func() {
+			writeNginx(`echo "nginx: [emerg] unknown directive" >&2; exit 1`)
+			session := runCliWithArgs([]string{"validate", "-conf", confPath, "-nginx", nginxPath}, []string{"PORT=8080"}, 3)
+			Expect(session.Err).To(gbytes.Say(`unknown directive`))
+		})
+
+		It("exits with 4 when nginx cannot be run", func() {
+			session := runCliWithArgs([]string{"validate", "-conf", confPath, "-nginx", filepath.Join(tmpDir, "missing")}, []string{"PORT=8080"}, 4)
+			Expect(session.Err).To(gbytes.Say(`Could not run nginx`))
+		})
+	})
+
+	Describe("vars", func() {
+		It("lists the referenced variables and where staging and runtime take them from", func() {
+			bpYMLPath := filepath.Join(tmpDir, "buildpack.yml")
+			Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  plaintext_env_vars: [PLAIN]\n  validation_env:\n    SAMPLED: x\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(tmpDir, "site.conf"), []byte("\nset $a {{env_default \"PLAIN\" \"x\"}};\nset $b {{env \"SAMPLED\"}};"), 0644)).To(Succeed())
+			Expect(os.WriteFile(confPath, []byte("listen {{port}};\ninclude site.conf;\nproxy_pass {{required \"UPSTREAM_URL\"}};\n{{if env \"UNSET\"}}gzip on;{{end}}"), 0644)).To(Succeed())
+
+			session := runCliWithArgs([]string{"vars", "-conf", confPath, "-buildpack-yml-path", bpYMLPath}, []string{"PORT=8080"}, 0)
+			Expect(session.Out).To(gbytes.Say(`VARIABLE\s+FUNCTION\s+SET\s+STAGING\s+RUNTIME\s+LOCATION\n`))
+			Expect(session.Out).To(gbytes.Say(`PORT\s+port\s+yes\s+env\s+env\s+nginx.conf:1\n`))
+			Expect(session.Out).To(gbytes.Say(`UPSTREAM_URL\s+required\s+no\s+placeholder\s+env\s+nginx.conf:3\n`))
+			Expect(session.Out).To(gbytes.Say(`UNSET\s+env\s+no\s+unset\s+env\s+nginx.conf:4\n`))
+			Expect(session.Out).To(gbytes.Say(`PLAIN\s+env_default\s+no\s+default\s+env\s+site.conf:2\n`))
+			Expect(session.Out).To(gbytes.Say(`SAMPLED\s+env\s+no\s+validation_env\s+env\s+site.conf:3\n`))
+		})
+	})
+
+	Describe("modules", func() {
+		It("lists modules with local modules hiding global ones", func() {
+			localModulePath := filepath.Join(tmpDir, "local")
+			globalModulePath := filepath.Join(tmpDir, "global")
+			Expect(os.Mkdir(localModulePath, 0755)).To(Succeed())
+			Expect(os.Mkdir(globalModulePath, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(localModulePath, "ngx_stream_module.so"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(globalModulePath, "ngx_stream_module.so"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(globalModulePath, "ngx_http_geoip_module.so"), nil, 0644)).To(Succeed())
+
+			session := runCliWithArgs([]string{"modules", "-local-modules", localModulePath, "-global-modules", globalModulePath}, nil, 0)
+			Expect(session.Out).To(gbytes.Say(`MODULE\s+SOURCE\s+PATH\n`))
+			Expect(session.Out).To(gbytes.Say(`ngx_http_geoip_module\s+global\s+` + filepath.Join(globalModulePath, "ngx_http_geoip_module.so") + `\n`))
+			Expect(session.Out).To(gbytes.Say(`ngx_stream_module\s+local\s+` + filepath.Join(localModulePath, "ngx_stream_module.so") + `\n`))
+		})
+	})
+})
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// environment backs the template functions that read environment variables.
+type environment struct {
+	// plainText lists the variables that are resolved in the first,
+	// unescaped phase.
+	plainText []string
+	// strict makes env fail on variables that are not set.
+	strict bool
+	// samples take precedence over the environment and placeholders stand
+	// in for variables that are not set. Both are only used to validate the
+	// config while staging.
+	samples      map[string]string
+	placeholders map[string]string
+}
+
+// lookup returns the value of key. placeholders are left to the caller, since
+// env_default prefers its fallback over them.
+func (e environment) lookup(key string) (string, bool) {
+	if value, ok := e.samples[key]; ok {
+		return value, true
+	}
+	return os.LookupEnv(key)
+}
+
+func (e environment) placeholder(key string) (string, bool) {
+	value, ok := e.placeholders[key]
+	return value, ok
+}
+
+// stagingSource returns where fn takes the value of key from when the config
+// is checked while staging, following Env, Required and EnvDefault.
+func (e environment) stagingSource(fn, key string) string {
+	if _, ok := e.samples[key]; ok {
+		return "validation_env"
+	}
+	if value, ok := os.LookupEnv(key); ok && (value != "" || fn == "env") {
+		return "env"
+	}
+	if fn == "env_default" {
+		return "default"
+	}
+	if _, ok := e.placeholder(key); ok {
+		return "placeholder"
+	}
+	return "unset"
+}
+
+func (e environment) isPlainText(key string) bool {
+	for _, plainTextKey := range e.plainText {
+		if key == plainTextKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (e environment) Env(key string) (string, error) {
+	value, ok := e.lookup(key)
+	if !ok {
+		if placeholder, ok := e.placeholder(key); ok {
+			return placeholder, nil
+		}
+		if e.strict {
+			return "", fmt.Errorf("environment variable %q is not set; use env_default to allow it to be missing", key)
+		}
+	}
+	return value, nil
+}
+
+// Required returns the value of key and fails if it is unset or empty.
+func (e environment) Required(key string) (string, error) {
+	value, _ := e.lookup(key)
+	if value == "" {
+		if placeholder, ok := e.placeholder(key); ok {
+			return placeholder, nil
+		}
+		return "", fmt.Errorf("required environment variable %q is not set", key)
+	}
+	return value, nil
+}
+
+// EnvDefault returns the value of key, or fallback if it is unset or empty.
+func (e environment) EnvDefault(key, fallback string) string {
+	if value, _ := e.lookup(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// plainTextFuncs returns the environment functions of the first phase, which
+// resolve plaintext variables and pass through the rest.
+func (e environment) plainTextFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"env": func(key string) (string, error) {
+			if !e.isPlainText(key) {
+				return singleArgIdentity("env")(key), nil
+			}
+			return e.Env(key)
+		},
+		"required": func(key string) (string, error) {
+			if !e.isPlainText(key) {
+				return singleArgIdentity("required")(key), nil
+			}
+			return e.Required(key)
+		},
+		"env_default": func(key, fallback string) string {
+			if !e.isPlainText(key) {
+				return multiArgIdentity("env_default")(key, fallback)
+			}
+			return e.EnvDefault(key, fallback)
+		},
+	}
+}
+
+// nginxFuncs returns the environment functions of the second phase.
+func (e environment) nginxFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"env":         e.Env,
+		"required":    e.Required,
+		"env_default": e.EnvDefault,
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// nginxSafe marks the output of a template function as valid nginx syntax
+// that must be inserted as is, such as a complete load_module directive.
+type nginxSafe string
+
+// quoteContext is the nginx lexical context a template action appears in.
+type quoteContext string
+
+const (
+	contextBare        quoteContext = "bare"
+	contextDoubleQuote quoteContext = "double_quote"
+	contextSingleQuote quoteContext = "single_quote"
+	contextComment     quoteContext = "comment"
+	// contextInToken is an unquoted action that continues a token, such as
+	// the host in http://{{env "HOST"}}/path.
+	contextInToken quoteContext = "in_token"
+	// contextTokenStart is an unquoted action that starts a token which the
+	// template continues, such as {{env "DIR"}}/index.html.
+	contextTokenStart quoteContext = "token_start"
+)
+
+const escaperFunc = "_nginx_escape"
+
+var escaperFuncMap = template.FuncMap{
+	escaperFunc: escapeNginx,
+}
+
+// lexState tracks where in the nginx syntax the template text has got to.
+type lexState struct {
+	context    quoteContext
+	tokenStart bool
+	escaped    bool
+}
+
+// escapeTemplate rewrites every action in t so that its output passes through
+// escapeNginx with the quoting context the action appears in. It plays the
+// role html/template's contextual escaping plays for HTML, but follows the
+// tokenizer rules of nginx.conf.
+func escapeTemplate(t *template.Template) error {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+		state := lexState{context: contextBare, tokenStart: true}
+		if _, err := escapeList(tmpl.Tree.Root, state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func escapeList(list *parse.ListNode, state lexState) (lexState, error) {
+	if list == nil {
+		return state, nil
+	}
+
+	var err error
+	for i, node := range list.Nodes {
+		switch node := node.(type) {
+		case *parse.TextNode:
+			state = state.advance(string(node.Text))
+		case *parse.ActionNode:
+			if len(node.Pipe.Decl) == 0 {
+				context := state.context
+				switch {
+				case context == contextBare && !state.tokenStart:
+					context = contextInToken
+				case context == contextBare && continuesToken(list.Nodes[i+1:]):
+					context = contextTokenStart
+				}
+				node.Pipe.Cmds = append(node.Pipe.Cmds, escaperCommand(node.Pipe, context))
+			}
+			state.tokenStart = false
+		case *parse.IfNode:
+			state, err = escapeBranch(&node.BranchNode, state, "if")
+		case *parse.RangeNode:
+			state, err = escapeBranch(&node.BranchNode, state, "range")
+		case *parse.WithNode:
+			state, err = escapeBranch(&node.BranchNode, state, "with")
+		}
+		if err != nil {
+			return state, err
+		}
+	}
+
+	return state, nil
+}
+
+// continuesToken reports whether the nodes that follow an action add to the
+// token that the action is in. What follows the end of a branch is not
+// known, so the token is taken to end there.
+func continuesToken(next []parse.Node) bool {
+	if len(next) == 0 {
+		return false
+	}
+
+	switch node := next[0].(type) {
+	case *parse.TextNode:
+		if len(node.Text) == 0 {
+			return continuesToken(next[1:])
+		}
+		return !strings.ContainsRune(" \t\r\n;{}", rune(node.Text[0]))
+	case *parse.ActionNode:
+		if len(node.Pipe.Decl) > 0 {
+			return continuesToken(next[1:])
+		}
+		return true
+	}
+	return false
+}
+
+func escapeBranch(branch *parse.BranchNode, state lexState, name string) (lexState, error) {
+	afterList, err := escapeList(branch.List, state)
+	if err != nil {
+		return state, err
+	}
+	afterElse, err := escapeList(branch.ElseList, state)
+	if err != nil {
+		return state, err
+	}
+
+	if afterList.context != afterElse.context || (name == "range" && afterList.context != state.context) {
+		msg := fmt.Sprintf("{{%s}} branches end in different quoting contexts (%s, %s)", name, afterList.context, afterElse.context)
+		return state, &templateError{Line: branch.Line, Msg: msg}
+	}
+
+	afterList.tokenStart = afterList.tokenStart && afterElse.tokenStart
+	return afterList, nil
+}
+
+func escaperCommand(pipe *parse.PipeNode, context quoteContext) *parse.CommandNode {
+	return &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      pipe.Pos,
+		Args: []parse.Node{
+			parse.NewIdentifier(escaperFunc).SetPos(pipe.Pos),
+			&parse.StringNode{NodeType: parse.NodeString, Pos: pipe.Pos, Quoted: fmt.Sprintf("%q", context), Text: string(context)},
+		},
+	}
+}
+
+// advance follows ngx_conf_read_token over text: quotes only open a string
+// at the start of a token and # only starts a comment there.
+func (s lexState) advance(text string) lexState {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		if s.escaped {
+			s.escaped = false
+			continue
+		}
+
+		switch s.context {
+		case contextComment:
+			if c == '\n' {
+				s.context = contextBare
+				s.tokenStart = true
+			}
+		case contextDoubleQuote, contextSingleQuote:
+			if c == '\\' {
+				s.escaped = true
+			} else if (c == '"' && s.context == contextDoubleQuote) || (c == '\'' && s.context == contextSingleQuote) {
+				s.context = contextBare
+			}
+		default:
+			switch {
+			case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '{' || c == '}':
+				s.tokenStart = true
+			case c == '#' && s.tokenStart:
+				s.context = contextComment
+			case c == '"' && s.tokenStart:
+				s.context = contextDoubleQuote
+				s.tokenStart = false
+			case c == '\'' && s.tokenStart:
+				s.context = contextSingleQuote
+				s.tokenStart = false
+			case c == '\\':
+				s.escaped = true
+				s.tokenStart = false
+			default:
+				s.tokenStart = false
+			}
+		}
+	}
+
+	return s
+}
+
+// escapeNginx makes the result of a template action safe to insert into
+// nginx.conf in the given context. Its last argument is the value of the
+// pipeline it was appended to.
+func escapeNginx(context string, args ...interface{}) (string, error) {
+	if len(args) == 1 {
+		if safe, ok := args[0].(nginxSafe); ok {
+			return string(safe), nil
+		}
+	}
+	value := fmt.Sprint(args...)
+
+	if quoteContext(context) != contextComment && strings.Contains(value, "$") {
+		return "", fmt.Errorf("refusing to insert a value containing '$', which nginx would expand as a variable; list the variable in nginx.plaintext_env_vars if it holds nginx syntax")
+	}
+
+	switch quoteContext(context) {
+	case contextDoubleQuote:
+		return escapeQuoted(value, '"'), nil
+	case contextSingleQuote:
+		return escapeQuoted(value, '\''), nil
+	case contextComment:
+		return strings.NewReplacer("\r", " ", "\n", " ").Replace(value), nil
+	case contextInToken:
+		return escapeInToken(value)
+	case contextTokenStart:
+		return escapeTokenStart(value)
+	default:
+		return escapeBare(value)
+	}
+}
+
+func escapeQuoted(value string, quote byte) string {
+	return strings.NewReplacer(`\`, `\\`, string(quote), `\`+string(quote)).Replace(value)
+}
+
+// escapeBare quotes values that nginx would otherwise split into several
+// arguments or leave out, and refuses values that would end the directive or
+// block they are inserted into.
+func escapeBare(value string) (string, error) {
+	if i := strings.IndexAny(value, ";{}"); i >= 0 {
+		return "", fmt.Errorf("refusing to insert a value containing %q outside of quotes; quote the template action if the value may contain it", value[i])
+	}
+
+	if value == "" {
+		return `""`, nil
+	}
+
+	if strings.ContainsAny(value, " \t\r\n\"'#\\") {
+		return `"` + escapeQuoted(value, '"') + `"`, nil
+	}
+
+	return value, nil
+}
+
+// escapeInToken escapes values that continue a token, where quotes no longer
+// open a string. nginx has escapes for tabs and newlines there but not for
+// spaces, so values with spaces are refused rather than split.
+func escapeInToken(value string) (string, error) {
+	if i := strings.IndexAny(value, " ;{}"); i >= 0 {
+		return "", fmt.Errorf("refusing to insert a value containing %q into part of an argument; quote the whole argument if the value may contain it", value[i])
+	}
+
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\r", `\r`, "\n", `\n`).Replace(value), nil
+}
+
+// escapeTokenStart escapes values that start a token which the template
+// continues, so they cannot be quoted. A quote that would open a string is
+// escaped, while a # that would start a comment has no escape.
+func escapeTokenStart(value string) (string, error) {
+	if strings.HasPrefix(value, "#") {
+		return "", fmt.Errorf("refusing to insert a value starting with '#' at the start of an argument; quote the whole argument if the value may start with it")
+	}
+
+	escaped, err := escapeInToken(value)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'") {
+		escaped = `\` + escaped
+	}
+	return escaped, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// lineMap maps the lines of a rendered file back to the template: the i-th
+// element is the template line that rendered line i+1 starts on.
+type lineMap []int
+
+// source returns the template line of the rendered line, or line itself if
+// the map does not know it.
+func (m lineMap) source(line int) int {
+	if line < 1 || line > len(m) {
+		return line
+	}
+	return m[line-1]
+}
+
+// compose returns the map from the output of a second rendering, mapped by
+// m, through first back to the original template.
+func (m lineMap) compose(first lineMap) lineMap {
+	composed := make(lineMap, len(m))
+	for i, line := range m {
+		composed[i] = first.source(line)
+	}
+	return composed
+}
+
+// Markers are written before the output of every node while rendering and
+// removed afterwards. They hold the template line the node starts on and
+// whether the node is text, whose output follows the template line by line,
+// or an action, whose output all stems from one line.
+const (
+	markerDelim  = '\x00'
+	markerText   = 'T'
+	markerAction = 'A'
+)
+
+// markLines adds a marker before every node of the templates in t. src is
+// the text t was parsed from.
+func markLines(t *template.Template, src string) {
+	starts := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	lineAt := func(pos parse.Pos) int {
+		return sort.Search(len(starts), func(i int) bool { return starts[i] > int(pos) })
+	}
+
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			markList(tmpl.Tree.Root, lineAt)
+		}
+	}
+}
+
+func markList(list *parse.ListNode, lineAt func(parse.Pos) int) {
+	if list == nil {
+		return
+	}
+
+	nodes := []parse.Node{}
+	for _, node := range list.Nodes {
+		kind := byte(markerAction)
+		switch node := node.(type) {
+		case *parse.TextNode:
+			kind = markerText
+		case *parse.IfNode:
+			markList(node.List, lineAt)
+			markList(node.ElseList, lineAt)
+		case *parse.RangeNode:
+			markList(node.List, lineAt)
+			markList(node.ElseList, lineAt)
+		case *parse.WithNode:
+			markList(node.List, lineAt)
+			markList(node.ElseList, lineAt)
+		}
+		nodes = append(nodes, markerNode(node.Position(), lineAt(node.Position()), kind), node)
+	}
+	list.Nodes = nodes
+}
+
+func markerNode(pos parse.Pos, line int, kind byte) *parse.ActionNode {
+	marker := string([]byte{markerDelim, kind}) + strconv.Itoa(line) + string(markerDelim)
+	return &parse.ActionNode{
+		NodeType: parse.NodeAction,
+		Pos:      pos,
+		Line:     line,
+		Pipe: &parse.PipeNode{
+			NodeType: parse.NodePipe,
+			Pos:      pos,
+			Line:     line,
+			Cmds: []*parse.CommandNode{{
+				NodeType: parse.NodeCommand,
+				Pos:      pos,
+				Args:     []parse.Node{&parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(marker), Text: marker}},
+			}},
+		},
+	}
+}
+
+// unmarkLines removes the markers that markLines caused from out and returns
+// the map of the remaining lines to the template.
+func unmarkLines(out []byte) ([]byte, lineMap) {
+	clean := bytes.Buffer{}
+	lines := lineMap{}
+	line, text, lineStart := 1, true, true
+
+	for i := 0; i < len(out); i++ {
+		if out[i] == markerDelim {
+			end := bytes.IndexByte(out[i+1:], markerDelim)
+			text = out[i+1] == markerText
+			line, _ = strconv.Atoi(string(out[i+2 : i+1+end]))
+			i += end + 1
+			continue
+		}
+
+		if lineStart {
+			lines = append(lines, line)
+			lineStart = false
+		}
+		clean.WriteByte(out[i])
+		if out[i] == '\n' {
+			lineStart = true
+			if text {
+				line++
+			}
+		}
+	}
+
+	return clean.Bytes(), lines
+}
+
+// writeLineMap writes the line maps of files as JSON to path, keyed by the
+// names of the files relative to the main config's directory.
+func writeLineMap(path string, r *renderer, files []renderedFile) error {
+	maps := map[string]lineMap{}
+	for _, file := range files {
+		name := r.name(file.Path)
+		if strings.HasPrefix(name, "..") {
+			continue
+		}
+		maps[name] = file.Lines
+	}
+
+	contents, err := json.Marshal(maps)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		return fmt.Errorf("Could not write line map: %s", err)
+	}
+	return nil
+}
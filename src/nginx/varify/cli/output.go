@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/launcher"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
+)
+
+type renderedFile struct {
+	Path     string
+	Source   []byte
+	Rendered []byte
+	// Lines maps the lines of Rendered back to Source.
+	Lines lineMap
+}
+
+// renderTree renders the config file at filename and every file it includes,
+// main config file first. Nothing is written, so a failure leaves the sources
+// untouched.
+func renderTree(r *renderer, filename string) ([]renderedFile, error) {
+	includes, err := supply.ResolveIncludes(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %s: %s", filename, err)
+	}
+
+	files := []renderedFile{}
+	for _, confFile := range append([]string{filename}, includes...) {
+		source, err := os.ReadFile(confFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read config file: %s: %s", confFile, err)
+		}
+		rendered, lines, err := r.render(confFile, source)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, renderedFile{Path: confFile, Source: source, Rendered: rendered, Lines: lines})
+	}
+
+	return files, nil
+}
+
+// writeInPlace overwrites every config file with its rendered contents.
+func writeInPlace(files []renderedFile) error {
+	for _, file := range files {
+		if err := os.WriteFile(file.Path, file.Rendered, 0644); err != nil {
+			return fmt.Errorf("Could not write config file: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// writeToDir recreates outputDir as a mirror of root holding the rendered
+// config files. Everything else in root is symlinked, so that relative
+// includes of files that need no rendering, such as mime.types, keep working
+// when nginx is pointed at the rendered copy. outputDir may not hold root,
+// since replacing it would delete the templates.
+func writeToDir(root, outputDir string, files []renderedFile) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	outputDir, err = filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(outputDir, root); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Refusing to clear output directory %s, which holds the config directory %s", outputDir, root)
+	}
+
+	rendered := map[string][]byte{}
+	dirs := map[string]bool{}
+	for _, file := range files {
+		path, err := filepath.Abs(file.Path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if !bytes.Equal(file.Source, file.Rendered) {
+				return fmt.Errorf("%s is included from outside of %s and cannot be rendered into %s", file.Path, root, outputDir)
+			}
+			continue
+		}
+
+		rendered[rel] = file.Rendered
+		for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	if err := checkAbsoluteIncludes(root, files); err != nil {
+		return err
+	}
+
+	// nginx may read the previous render at any time, so the new one is
+	// written next to it and then moved into place.
+	if err := os.MkdirAll(filepath.Dir(outputDir), 0755); err != nil {
+		return fmt.Errorf("Could not create output directory: %s", err)
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(outputDir), "."+filepath.Base(outputDir)+".new-")
+	if err != nil {
+		return fmt.Errorf("Could not create output directory: %s", err)
+	}
+	defer os.RemoveAll(staging)
+	if err := os.Chmod(staging, 0755); err != nil {
+		return fmt.Errorf("Could not create output directory: %s", err)
+	}
+
+	if err := mirrorDir(root, outputDir, staging, ".", rendered, dirs); err != nil {
+		return err
+	}
+	if err := launcher.ReplaceDir(outputDir, staging); err != nil {
+		return fmt.Errorf("Could not replace output directory: %s", err)
+	}
+	return nil
+}
+
+// checkAbsoluteIncludes refuses includes of rendered files by their absolute
+// path, which would point nginx at the templates in root instead of the
+// rendered copies.
+func checkAbsoluteIncludes(root string, files []renderedFile) error {
+	templates := []string{}
+	for _, file := range files {
+		if !bytes.Equal(file.Source, file.Rendered) {
+			if path, err := filepath.Abs(file.Path); err == nil {
+				templates = append(templates, path)
+			}
+		}
+	}
+
+	for _, file := range files {
+		conf, _ := nginxconf.Parse(file.Path, file.Source)
+		if conf == nil {
+			continue
+		}
+		name := file.Path
+		if path, err := filepath.Abs(file.Path); err == nil {
+			if rel, err := filepath.Rel(root, path); err == nil {
+				name = rel
+			}
+		}
+		for _, d := range conf.Find("include") {
+			if len(d.Args) != 1 || d.Args[0].HasTemplate() || !filepath.IsAbs(d.Arg(0)) {
+				continue
+			}
+			for _, template := range templates {
+				if matched, _ := filepath.Match(filepath.Clean(d.Arg(0)), template); matched {
+					rel, _ := filepath.Rel(root, template)
+					return fmt.Errorf("%s:%d includes %s by its absolute path, so nginx would read the template instead of the rendered file; include it as %s instead",
+						name, d.Pos.Line, d.Arg(0), rel)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// mirrorDir mirrors dir of root into stagingDir, leaving out outputDir and
+// the dirs next to it that writeToDir swaps it with.
+func mirrorDir(root, outputDir, stagingDir, dir string, rendered map[string][]byte, dirs map[string]bool) error {
+	entries, err := os.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		return fmt.Errorf("Could not read directory: %s", err)
+	}
+
+	for _, entry := range entries {
+		rel := filepath.Join(dir, entry.Name())
+		source := filepath.Join(root, rel)
+		target := filepath.Join(stagingDir, rel)
+		if source == outputDir || strings.HasPrefix(source, filepath.Join(filepath.Dir(outputDir), "."+filepath.Base(outputDir)+".")) {
+			continue
+		}
+
+		if contents, ok := rendered[rel]; ok {
+			if err := os.WriteFile(target, contents, 0644); err != nil {
+				return fmt.Errorf("Could not write config file: %s", err)
+			}
+		} else if dirs[rel] {
+			if err := os.Mkdir(target, 0755); err != nil {
+				return fmt.Errorf("Could not create directory: %s", err)
+			}
+			if err := mirrorDir(root, outputDir, stagingDir, rel, rendered, dirs); err != nil {
+				return err
+			}
+		} else if err := os.Symlink(source, target); err != nil {
+			return fmt.Errorf("Could not link %s: %s", rel, err)
+		}
+	}
+
+	return nil
+}
+
+// printRendered writes every rendered config file to w, each preceded by a
+// comment naming it.
+func printRendered(w io.Writer, root string, files []renderedFile) error {
+	for _, file := range files {
+		name := file.Path
+		if rel, err := filepath.Rel(root, file.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+
+		contents := file.Rendered
+		if len(contents) > 0 && contents[len(contents)-1] != '\n' {
+			contents = append(contents, '\n')
+		}
+		if _, err := fmt.Fprintf(w, "# --- %s ---\n%s", name, contents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
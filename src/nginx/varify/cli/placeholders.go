@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/nginxconf"
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
+)
+
+// envCallPattern matches the env and required calls in a template action.
+var envCallPattern = regexp.MustCompile(`\b(?:env|required)\s+("(?:[^"\\]|\\.)*")`)
+
+// addressDirectives take an address, which nginx -t resolves, so their
+// placeholders must be addresses that resolve without DNS.
+var addressDirectives = map[string]string{
+	"proxy_pass":     "http://127.0.0.1:1",
+	"grpc_pass":      "127.0.0.1:1",
+	"fastcgi_pass":   "127.0.0.1:1",
+	"uwsgi_pass":     "127.0.0.1:1",
+	"scgi_pass":      "127.0.0.1:1",
+	"memcached_pass": "127.0.0.1:1",
+	"server":         "127.0.0.1:1",
+	"resolver":       "127.0.0.1",
+}
+
+// findPlaceholders returns a value for every variable that the config at
+// confPath reads with env or required, chosen to fit the directive where the
+// variable is first used. Only the structure of the config can be checked
+// with these values, so they are used while staging, when runtime-only
+// variables are missing.
+func findPlaceholders(confPath string) map[string]string {
+	placeholders := map[string]string{}
+
+	includes, _ := supply.ResolveIncludes(confPath)
+	for _, path := range append([]string{confPath}, includes...) {
+		// On a syntax error, the directives before it still count.
+		conf, _ := nginxconf.ParseFile(path)
+		if conf == nil {
+			continue
+		}
+
+		conf.Walk(func(d *nginxconf.Directive, _ []*nginxconf.Directive) bool {
+			if d.Template {
+				return true
+			}
+			for _, arg := range d.Args {
+				for _, match := range envCallPattern.FindAllStringSubmatchIndex(arg.Raw, -1) {
+					key, err := strconv.Unquote(arg.Raw[match[2]:match[3]])
+					if err != nil {
+						continue
+					}
+					if _, ok := placeholders[key]; !ok {
+						actionStart := strings.LastIndex(arg.Raw[:match[0]], "{{")
+						placeholders[key] = placeholderFor(d.Name, arg, arg.Raw[:max(actionStart, 0)])
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	return placeholders
+}
+
+// placeholderFor returns a value for a variable used in arg of the directive
+// name. before is the text of the argument that precedes the action.
+func placeholderFor(name string, arg nginxconf.Arg, before string) string {
+	if address, ok := addressDirectives[name]; ok {
+		switch {
+		case arg.IsTemplate():
+			return address
+		case strings.HasSuffix(before, ":"):
+			return "1"
+		default:
+			return "127.0.0.1"
+		}
+	}
+
+	if !arg.IsTemplate() {
+		return "placeholder"
+	}
+
+	switch {
+	case name == "listen":
+		return "8080"
+	case name == "server_name":
+		return "placeholder.invalid"
+	case strings.HasSuffix(name, "_timeout"):
+		return "60s"
+	case strings.HasSuffix(name, "_size"):
+		return "1k"
+	default:
+		return "placeholder"
+	}
+}
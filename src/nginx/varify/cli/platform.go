@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// memoryPerWorkerMB is the memory that warrants one more worker process.
+	// Cloud Foundry hands out CPU shares in proportion to memory, so a small
+	// container gets a fraction of the host CPUs it can see.
+	memoryPerWorkerMB = 1024
+	// memoryPerConnectionKB is the memory budgeted for the buffers of one
+	// connection.
+	memoryPerConnectionKB = 32
+	minWorkerConnections  = 128
+	maxWorkerRlimitNofile = 65536
+)
+
+// platformFuncNames are the template functions backed by platform. None of
+// them take arguments.
+var platformFuncNames = []string{
+	"app_uris",
+	"app_name",
+	"instance_index",
+	"memory_limit_mb",
+	"disk_limit_mb",
+	"cpu_count",
+	"auto_worker_processes",
+	"auto_worker_connections",
+	"auto_worker_rlimit_nofile",
+}
+
+// platform looks up facts about the app and the container it runs in.
+type platform struct {
+	vcapApplication string
+	cgroupRoot      string
+}
+
+func newPlatform(vcapApplication string) *platform {
+	return &platform{vcapApplication: vcapApplication, cgroupRoot: "/sys/fs/cgroup"}
+}
+
+func (p *platform) application(path string) (gjson.Result, error) {
+	if p.vcapApplication == "" {
+		return gjson.Result{}, errors.New("VCAP_APPLICATION is not set")
+	}
+	if !gjson.Valid(p.vcapApplication) {
+		return gjson.Result{}, errors.New("VCAP_APPLICATION is not valid JSON")
+	}
+
+	value := gjson.Get(p.vcapApplication, path)
+	if !value.Exists() {
+		return gjson.Result{}, fmt.Errorf("VCAP_APPLICATION has no %q", path)
+	}
+	return value, nil
+}
+
+// AppURIs returns the host names of the routes mapped to the app, separated
+// by spaces, for use as server_name arguments.
+func (p *platform) AppURIs() (nginxSafe, error) {
+	uris, err := p.application("application_uris")
+	if err != nil {
+		return "", err
+	}
+
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, uri := range uris.Array() {
+		host := strings.SplitN(uri.String(), "/", 2)[0]
+		if host == "" || seen[host] {
+			continue
+		}
+		if strings.ContainsAny(host, " \t\r\n\"'#\\;{}") {
+			return "", fmt.Errorf("application URI %q is not a valid host name", uri.String())
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return "", errors.New("no routes are mapped to the app")
+	}
+
+	return nginxSafe(strings.Join(hosts, " ")), nil
+}
+
+func (p *platform) AppName() (string, error) {
+	name, err := p.application("application_name")
+	if err != nil {
+		return "", err
+	}
+	return name.String(), nil
+}
+
+func (p *platform) InstanceIndex() (int, error) {
+	if index := os.Getenv("CF_INSTANCE_INDEX"); index != "" {
+		return strconv.Atoi(index)
+	}
+
+	index, err := p.application("instance_index")
+	if err != nil {
+		return 0, err
+	}
+	return int(index.Int()), nil
+}
+
+// MemoryLimitMB returns the memory limit of the app from MEMORY_LIMIT, the
+// limits in VCAP_APPLICATION or the memory cgroup, in that order.
+func (p *platform) MemoryLimitMB() (int, error) {
+	limit, ok, err := p.memoryLimitMB()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.New("could not determine the memory limit: MEMORY_LIMIT, VCAP_APPLICATION and the memory cgroup do not set one")
+	}
+	return limit, nil
+}
+
+// memoryLimitMB is MemoryLimitMB for the functions that do without a limit
+// when none is set, which an invalid MEMORY_LIMIT still fails.
+func (p *platform) memoryLimitMB() (int, bool, error) {
+	if limit := os.Getenv("MEMORY_LIMIT"); limit != "" {
+		mb, err := parseMemoryLimit(limit)
+		return mb, err == nil, err
+	}
+
+	if mem := gjson.Get(p.vcapApplication, "limits.mem"); mem.Exists() {
+		return int(mem.Int()), true, nil
+	}
+
+	limit, ok := p.cgroupMemoryLimitMB()
+	return limit, ok, nil
+}
+
+func (p *platform) DiskLimitMB() (int, error) {
+	disk, err := p.application("limits.disk")
+	if err != nil {
+		return 0, err
+	}
+	return int(disk.Int()), nil
+}
+
+// CPUCount returns the number of CPUs the container may use, which is the CPU
+// quota of the cgroup rounded up, or every CPU if there is no quota.
+func (p *platform) CPUCount() int {
+	cpus := runtime.NumCPU()
+	if quota, ok := p.cgroupCPUQuota(); ok && quota < cpus {
+		return quota
+	}
+	return cpus
+}
+
+// AutoWorkerProcesses returns a worker_processes value that does not
+// oversubscribe the CPU share of the container.
+func (p *platform) AutoWorkerProcesses() (int, error) {
+	workers := p.CPUCount()
+
+	memory, ok, err := p.memoryLimitMB()
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		byMemory := (memory + memoryPerWorkerMB - 1) / memoryPerWorkerMB
+		if byMemory < 1 {
+			byMemory = 1
+		}
+		if byMemory < workers {
+			workers = byMemory
+		}
+	}
+
+	return workers, nil
+}
+
+// AutoWorkerRlimitNofile returns a worker_rlimit_nofile value within the hard
+// limit on open files of the container.
+func (p *platform) AutoWorkerRlimitNofile() (int, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, fmt.Errorf("could not read the open files limit: %w", err)
+	}
+
+	if limit.Max > maxWorkerRlimitNofile {
+		return maxWorkerRlimitNofile, nil
+	}
+	return int(limit.Max), nil
+}
+
+// AutoWorkerConnections returns a worker_connections value that fits both the
+// open files limit, allowing two files per proxied connection, and the memory
+// share of each worker.
+func (p *platform) AutoWorkerConnections() (int, error) {
+	nofile, err := p.AutoWorkerRlimitNofile()
+	if err != nil {
+		return 0, err
+	}
+	connections := nofile / 2
+
+	memory, ok, err := p.memoryLimitMB()
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		workers, err := p.AutoWorkerProcesses()
+		if err != nil {
+			return 0, err
+		}
+		byMemory := memory * 1024 / workers / memoryPerConnectionKB
+		if byMemory < connections {
+			connections = byMemory
+		}
+	}
+
+	if connections < minWorkerConnections {
+		connections = minWorkerConnections
+	}
+	return connections, nil
+}
+
+// cgroupCPUQuota reads the CPU quota from cgroup v2 cpu.max or cgroup v1
+// cpu.cfs_quota_us, rounded up to whole CPUs.
+func (p *platform) cgroupCPUQuota() (int, bool) {
+	var quota, period int64
+	if fields := strings.Fields(p.readCgroupFile("cpu.max")); len(fields) == 2 {
+		if fields[0] == "max" {
+			return 0, false
+		}
+		quota, _ = strconv.ParseInt(fields[0], 10, 64)
+		period, _ = strconv.ParseInt(fields[1], 10, 64)
+	} else {
+		quota, _ = strconv.ParseInt(p.readCgroupFile("cpu", "cpu.cfs_quota_us"), 10, 64)
+		period, _ = strconv.ParseInt(p.readCgroupFile("cpu", "cpu.cfs_period_us"), 10, 64)
+	}
+
+	if quota <= 0 || period <= 0 {
+		return 0, false
+	}
+	return int((quota + period - 1) / period), true
+}
+
+// cgroupMemoryLimitMB reads the memory limit from cgroup v2 memory.max or
+// cgroup v1 memory.limit_in_bytes.
+func (p *platform) cgroupMemoryLimitMB() (int, bool) {
+	value := p.readCgroupFile("memory.max")
+	if value == "" {
+		value = p.readCgroupFile("memory", "memory.limit_in_bytes")
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	// cgroup v1 reports an unlimited cgroup as a value close to the maximum
+	// int64, rounded down to the page size.
+	if err != nil || limit <= 0 || limit >= 1<<60 {
+		return 0, false
+	}
+	return int(limit / 1024 / 1024), true
+}
+
+func (p *platform) readCgroupFile(path ...string) string {
+	contents, err := os.ReadFile(filepath.Join(append([]string{p.cgroupRoot}, path...)...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// parseMemoryLimit parses a MEMORY_LIMIT value such as 512m or 2G into
+// megabytes.
+func parseMemoryLimit(limit string) (int, error) {
+	units := map[string]float64{"k": 1.0 / 1024, "m": 1, "g": 1024, "t": 1024 * 1024}
+
+	value := strings.ToLower(strings.TrimSpace(limit))
+	value = strings.TrimSuffix(value, "b")
+	multiplier := 1.0
+	if len(value) > 0 {
+		if m, ok := units[value[len(value)-1:]]; ok {
+			multiplier = m
+			value = value[:len(value)-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid MEMORY_LIMIT %q", limit)
+	}
+	return int(number * multiplier), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	textTemplate "text/template"
+)
+
+// renderer expands the template actions in config files. Rendering runs in
+// two phases: the first resolves plaintext_env_vars and passes every other
+// action through, the second resolves the rest and escapes their values for
+// nginx.
+type renderer struct {
+	// root is the directory of the main config file. Templates are named by
+	// their path relative to it.
+	root           string
+	env            environment
+	plainTextFuncs textTemplate.FuncMap
+	nginxFuncs     textTemplate.FuncMap
+}
+
+// render renders src, the contents of the config file at path, and maps the
+// lines of the result back to src.
+func (r *renderer) render(path string, src []byte) ([]byte, lineMap, error) {
+	name := r.name(path)
+
+	plainTextT, err := textTemplate.New(name).Option("missingkey=zero").Funcs(r.plainTextFuncs).Parse(string(src))
+	if err != nil {
+		return nil, nil, newTemplateError(name, err)
+	}
+	markLines(plainTextT, string(src))
+	plainText := bytes.Buffer{}
+	if err := plainTextT.Execute(&plainText, nil); err != nil {
+		return nil, nil, newTemplateError(name, err)
+	}
+	intermediate, plainTextLines := unmarkLines(plainText.Bytes())
+
+	// Errors from here on are located in the output of the first phase.
+	fail := func(err error) ([]byte, lineMap, error) {
+		err = newTemplateError(name, err)
+		if tmplErr, ok := err.(*templateError); ok {
+			tmplErr.Line = plainTextLines.source(tmplErr.Line)
+		}
+		return nil, nil, err
+	}
+
+	nginxT, err := textTemplate.New(name).Option("missingkey=zero").Funcs(r.nginxFuncs).Parse(string(intermediate))
+	if err != nil {
+		return fail(err)
+	}
+	if err := escapeTemplate(nginxT); err != nil {
+		return fail(err)
+	}
+	markLines(nginxT, string(intermediate))
+	rendered := bytes.Buffer{}
+	if err := nginxT.Execute(&rendered, nil); err != nil {
+		return fail(err)
+	}
+	out, lines := unmarkLines(rendered.Bytes())
+
+	return out, lines.compose(plainTextLines), nil
+}
+
+func (r *renderer) name(path string) string {
+	if rel, err := filepath.Rel(r.root, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// templateError is a failure to render a config file, located at a line of
+// that file.
+type templateError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *templateError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+var templateErrorPattern = regexp.MustCompile(`^template: .*?:(\d+)(?::\d+)?: (?:executing ".*?" at <.*?>: )?(?:error calling \w+: )?((?s).*)$`)
+
+// newTemplateError turns an error from text/template, which reads like
+// `template: nginx.conf:3:12: executing "nginx.conf" at <required "X">:
+// error calling required: ...`, into a templateError.
+func newTemplateError(file string, err error) error {
+	var tmplErr *templateError
+	if errors.As(err, &tmplErr) {
+		if tmplErr.File == "" {
+			tmplErr.File = file
+		}
+		return tmplErr
+	}
+
+	if match := templateErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return &templateError{File: file, Line: line, Msg: match[2]}
+	}
+	return &templateError{File: file, Msg: err.Error()}
+}
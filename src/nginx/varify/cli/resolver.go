@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// resolvConf holds the settings of resolv.conf that nginx needs to resolve
+// names like the rest of the container does.
+type resolvConf struct {
+	servers []string
+	// port is the port the nameservers listen on.
+	port   string
+	search []string
+	ndots  int
+}
+
+// readResolvConf reads the file at resolvConfPath. defaultNameServer is used
+// when the file lists no nameservers.
+func readResolvConf(resolvConfPath string, defaultNameServer string) (resolvConf, error) {
+	config, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return resolvConf{servers: []string{defaultNameServer}, ndots: 1}, err
+	}
+
+	conf := resolvConf{servers: config.Servers, port: config.Port, search: config.Search, ndots: config.Ndots}
+	if len(conf.servers) == 0 {
+		conf.servers = []string{defaultNameServer}
+	}
+	return conf, nil
+}
+
+// Nameservers returns the nameservers as arguments of the resolver directive,
+// with IPv6 addresses in brackets, followed by options such as valid=30s or
+// ipv6=off.
+func (c resolvConf) Nameservers(options ...string) (nginxSafe, error) {
+	args := []string{}
+	for _, server := range c.servers {
+		args = append(args, formatNameServer(server))
+	}
+
+	for _, option := range options {
+		if err := validateResolverOption(option); err != nil {
+			return "", err
+		}
+		args = append(args, option)
+	}
+
+	return nginxSafe(strings.Join(args, " ")), nil
+}
+
+// SearchDomains returns the search domains separated by spaces.
+func (c resolvConf) SearchDomains() nginxSafe {
+	return nginxSafe(strings.Join(c.search, " "))
+}
+
+func (c resolvConf) Ndots() int {
+	return c.ndots
+}
+
+// FQDN qualifies host with the first search domain the way the system
+// resolver does, since nginx's resolver does not apply search domains
+// itself. Names with at least ndots dots, or a trailing dot, are left as
+// they are.
+func (c resolvConf) FQDN(host string) string {
+	if strings.HasSuffix(host, ".") || strings.Count(host, ".") >= c.ndots || len(c.search) == 0 {
+		return host
+	}
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	return host + "." + strings.TrimSuffix(c.search[0], ".")
+}
+
+func formatNameServer(server string) string {
+	if strings.Contains(server, ":") && !strings.HasPrefix(server, "[") {
+		return "[" + server + "]"
+	}
+	return server
+}
+
+func validateResolverOption(option string) error {
+	key, value, found := strings.Cut(option, "=")
+	if !found || value == "" {
+		return fmt.Errorf("invalid resolver option %q, expected name=value", option)
+	}
+
+	switch key {
+	case "ipv4", "ipv6":
+		if value != "on" && value != "off" {
+			return fmt.Errorf("invalid resolver option %q, %s must be on or off", option, key)
+		}
+	case "valid", "status_zone":
+		if strings.ContainsAny(value, " \t\r\n\"'#\\;{}") {
+			return fmt.Errorf("invalid resolver option %q", option)
+		}
+	default:
+		return fmt.Errorf("unknown resolver option %q, expected valid, ipv4, ipv6 or status_zone", key)
+	}
+
+	return nil
+}
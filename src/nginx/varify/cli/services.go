@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// services looks up service bindings in the VCAP_SERVICES JSON that Cloud
+// Foundry provides to the app.
+type services struct {
+	vcapServices string
+}
+
+func newServices(vcapServices string) *services {
+	return &services{vcapServices: vcapServices}
+}
+
+// bindings returns every binding, in the order they appear in VCAP_SERVICES.
+func (s *services) bindings() ([]gjson.Result, error) {
+	if s.vcapServices == "" {
+		return nil, errors.New("VCAP_SERVICES is not set")
+	}
+	if !gjson.Valid(s.vcapServices) {
+		return nil, errors.New("VCAP_SERVICES is not valid JSON")
+	}
+
+	var result []gjson.Result
+	gjson.Parse(s.vcapServices).ForEach(func(_, instances gjson.Result) bool {
+		result = append(result, instances.Array()...)
+		return true
+	})
+	return result, nil
+}
+
+// find returns the only binding matching match, describing the bindings it
+// looked for with desc in errors.
+func (s *services) find(desc string, match func(binding gjson.Result) bool) (gjson.Result, error) {
+	bindings, err := s.bindings()
+	if err != nil {
+		return gjson.Result{}, err
+	}
+
+	var found []gjson.Result
+	for _, binding := range bindings {
+		if match(binding) {
+			found = append(found, binding)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return gjson.Result{}, fmt.Errorf("no service bound %s", desc)
+	case 1:
+		return found[0], nil
+	default:
+		return gjson.Result{}, fmt.Errorf("%d services bound %s, expected one", len(found), desc)
+	}
+}
+
+func (s *services) lookup(desc string, binding gjson.Result, path string) (string, error) {
+	value := binding.Get(path)
+	if !value.Exists() {
+		return "", fmt.Errorf("service bound %s has no %q", desc, path)
+	}
+	if value.Type == gjson.String {
+		return value.String(), nil
+	}
+	return value.Raw, nil
+}
+
+// Service returns the value at path, such as "credentials.host", in the
+// binding named name.
+func (s *services) Service(name, path string) (string, error) {
+	desc := fmt.Sprintf("with name %q", name)
+	binding, err := s.find(desc, func(binding gjson.Result) bool {
+		return binding.Get("name").String() == name
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.lookup(desc, binding, path)
+}
+
+// ServiceByTag returns the value at path in the binding tagged with tag.
+func (s *services) ServiceByTag(tag, path string) (string, error) {
+	desc := fmt.Sprintf("with tag %q", tag)
+	binding, err := s.find(desc, func(binding gjson.Result) bool {
+		for _, t := range binding.Get("tags").Array() {
+			if t.String() == tag {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.lookup(desc, binding, path)
+}
+
+// ServiceByLabel returns the value at path in the binding of the service
+// offering with the given label, such as "p.redis".
+func (s *services) ServiceByLabel(label, path string) (string, error) {
+	desc := fmt.Sprintf("with label %q", label)
+	binding, err := s.find(desc, func(binding gjson.Result) bool {
+		return binding.Get("label").String() == label
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.lookup(desc, binding, path)
+}
+
+// VolumeMount returns the directory the volume service named name is mounted
+// at.
+func (s *services) VolumeMount(name string) (string, error) {
+	desc := fmt.Sprintf("with name %q", name)
+	binding, err := s.find(desc, func(binding gjson.Result) bool {
+		return binding.Get("name").String() == name
+	})
+	if err != nil {
+		return "", err
+	}
+
+	dir := binding.Get("volume_mounts.0.container_dir")
+	if !dir.Exists() {
+		return "", fmt.Errorf("service bound %s has no volume mounts", desc)
+	}
+	return dir.String(), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const dnsTimeout = 2 * time.Second
+
+// UpstreamServers resolves every A and AAAA record of host and returns a
+// server directive for each address, for use inside an upstream block.
+// options, such as max_fails=3, are added to every server. When host has no
+// addresses a single server marked down stands in, since nginx refuses an
+// upstream block without servers.
+func (c resolvConf) UpstreamServers(host string, port int, options ...string) (nginxSafe, error) {
+	for _, option := range options {
+		if strings.ContainsAny(option, " \t\r\n\"'#\\;{}") {
+			return "", fmt.Errorf("invalid server option %q", option)
+		}
+	}
+
+	addresses, err := c.lookup(c.FQDN(host))
+	if err != nil {
+		return "", err
+	}
+
+	if len(addresses) == 0 {
+		log.Printf("Warning: %s has no addresses, its upstream servers are marked down", host)
+		return nginxSafe(serverLine(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), append([]string{"down"}, options...))), nil
+	}
+
+	lines := []string{}
+	for _, address := range addresses {
+		lines = append(lines, serverLine(net.JoinHostPort(address, strconv.Itoa(port)), options))
+	}
+	return nginxSafe(strings.Join(lines, "\n")), nil
+}
+
+func serverLine(address string, options []string) string {
+	return strings.Join(append([]string{"server", address}, options...), " ") + ";"
+}
+
+// lookup returns the sorted IPv4 and IPv6 addresses of name, asking each
+// nameserver in turn until one answers.
+func (c resolvConf) lookup(name string) ([]string, error) {
+	client := &dns.Client{Timeout: dnsTimeout}
+
+	var lastErr error
+	for _, server := range c.servers {
+		addresses, err := c.query(client, server, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return addresses, nil
+	}
+
+	return nil, fmt.Errorf("could not resolve %s: %w", name, lastErr)
+}
+
+func (c resolvConf) query(client *dns.Client, server, name string) ([]string, error) {
+	port := c.port
+	if port == "" {
+		port = "53"
+	}
+
+	addresses := []string{}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(name), qtype)
+
+		reply, _, err := client.Exchange(msg, net.JoinHostPort(strings.Trim(server, "[]"), port))
+		if err != nil {
+			return nil, err
+		}
+		if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+			return nil, fmt.Errorf("%s answered %s", server, dns.RcodeToString[reply.Rcode])
+		}
+
+		for _, answer := range reply.Answer {
+			switch record := answer.(type) {
+			case *dns.A:
+				addresses = append(addresses, record.A.String())
+			case *dns.AAAA:
+				addresses = append(addresses, record.AAAA.String())
+			}
+		}
+	}
+
+	sort.Strings(addresses)
+	return addresses, nil
+}
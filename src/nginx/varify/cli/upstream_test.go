@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/miekg/dns"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UpstreamServers", func() {
+	var resolv resolvConf
+
+	BeforeEach(func() {
+		records := map[string][]string{
+			"backend.apps.internal.": {
+				"backend.apps.internal. 0 IN A 10.255.0.2",
+				"backend.apps.internal. 0 IN A 10.255.0.1",
+				"backend.apps.internal. 0 IN AAAA fd00::1",
+			},
+		}
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		started := make(chan struct{})
+		server := &dns.Server{PacketConn: conn, NotifyStartedFunc: func() { close(started) }, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetReply(req)
+
+			question := req.Question[0]
+			rrs, ok := records[question.Name]
+			if !ok {
+				reply.Rcode = dns.RcodeNameError
+			}
+			for _, rr := range rrs {
+				record, err := dns.NewRR(rr)
+				Expect(err).NotTo(HaveOccurred())
+				if record.Header().Rrtype == question.Qtype {
+					reply.Answer = append(reply.Answer, record)
+				}
+			}
+			_ = w.WriteMsg(reply)
+		})}
+		go func() {
+			_ = server.ActivateAndServe()
+		}()
+		Eventually(started).Should(BeClosed())
+		DeferCleanup(server.Shutdown)
+
+		_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+		Expect(err).NotTo(HaveOccurred())
+		resolv = resolvConf{servers: []string{"127.0.0.1"}, port: port, search: []string{"apps.internal"}, ndots: 1}
+	})
+
+	It("emits a sorted server line for every address", func() {
+		servers, err := resolv.UpstreamServers("backend.apps.internal", 8080)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(servers)).To(Equal("server 10.255.0.1:8080;\nserver 10.255.0.2:8080;\nserver [fd00::1]:8080;"))
+	})
+
+	It("qualifies short names with the search domain", func() {
+		servers, err := resolv.UpstreamServers("backend", 8080, "max_fails=3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(servers)).To(HavePrefix("server 10.255.0.1:8080 max_fails=3;\n"))
+	})
+
+	It("marks a placeholder server down when the name has no addresses", func() {
+		servers, err := resolv.UpstreamServers("missing.apps.internal", 8080)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(servers)).To(Equal("server 127.0.0.1:8080 down;"))
+	})
+
+	It("rejects options that would break the directive", func() {
+		_, err := resolv.UpstreamServers("backend.apps.internal", 8080, "weight=1;")
+		Expect(err).To(MatchError(`invalid server option "weight=1;"`))
+	})
+
+	It("fails when no nameserver answers", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+		Expect(conn.Close()).To(Succeed())
+
+		resolv.port = port
+		_, err = resolv.UpstreamServers("backend.apps.internal", 8080)
+		Expect(err).To(MatchError(ContainSubstring("could not resolve backend.apps.internal: ")))
+	})
+
+	It("renders from a template with the port as a number", func() {
+		dir, err := os.MkdirTemp("", "upstream")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+
+		opts := renderOptions{confPath: filepath.Join(dir, "nginx.conf"), resolvConfPath: filepath.Join(dir, "resolv.conf"), defaultNameServer: "127.0.0.1"}
+		r, err := opts.newRenderer()
+		Expect(err).NotTo(HaveOccurred())
+		r.nginxFuncs["upstream_servers"] = resolv.UpstreamServers
+
+		out, _, err := r.render(opts.confPath, []byte(`upstream backend { {{upstream_servers "backend.apps.internal" 8080 "max_fails=3"}} }`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(Equal("upstream backend { server 10.255.0.1:8080 max_fails=3;\nserver 10.255.0.2:8080 max_fails=3;\nserver [fd00::1]:8080 max_fails=3; }"))
+	})
+})
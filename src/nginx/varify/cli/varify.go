@@ -1,194 +1,212 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
-	htmlTemplate "html/template"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	textTemplate "text/template"
 
 	"gopkg.in/yaml.v2"
 
-	"github.com/miekg/dns"
-
 	"github.com/cloudfoundry/libbuildpack"
-	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
+)
+
+// Exit codes shared by every command.
+const (
+	exitOK            = 0
+	exitRenderFailed  = 1
+	exitUsage         = 2
+	exitInvalidConfig = 3
+	exitNginxFailed   = 4
 )
 
 func main() {
+	log.SetFlags(0)
 
-	buildpackYMLPath := flag.String("buildpack-yml-path", "", "path to buildpack.yml file")
+	if len(os.Args) > 1 {
+		if cmd, ok := findCommand(os.Args[1]); ok {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+		switch os.Args[1] {
+		case "help", "-h", "-help", "--help":
+			printUsage(os.Stdout)
+			os.Exit(exitOK)
+		}
+	}
 
-	flag.Parse()
+	os.Exit(runLegacy(os.Args[1:]))
+}
 
-	flag.Args()
+// renderOptions are the flags that every command rendering templates shares.
+type renderOptions struct {
+	confPath          string
+	buildpackYMLPath  string
+	localModulePath   string
+	globalModulePath  string
+	resolvConfPath    string
+	defaultNameServer string
+	// generatedIncludesPath is the file that {{generated_includes}} pulls in.
+	generatedIncludesPath string
+	// validation substitutes nginx.validation_env and placeholders for
+	// variables that are only set at runtime.
+	validation bool
+}
 
-	filename := flag.Args()[0]
-	localModulePath := flag.Args()[1]
-	globalModulePath := flag.Args()[2]
-	resolvConfPath := "/etc/resolv.conf"
-	if len(flag.Args()) > 3 && len(flag.Args()[3]) > 0 {
-		resolvConfPath = flag.Args()[3]
-	}
+func (o *renderOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.confPath, "conf", "./nginx.conf", "path to the nginx.conf template")
+	fs.StringVar(&o.buildpackYMLPath, "buildpack-yml-path", "", "path to buildpack.yml file")
+	fs.StringVar(&o.localModulePath, "local-modules", "", "path to the user provided modules directory")
+	fs.StringVar(&o.globalModulePath, "global-modules", "", "path to the modules shipped with nginx")
+	fs.StringVar(&o.resolvConfPath, "resolv-conf", "/etc/resolv.conf", "path to the resolv.conf file to read nameservers from")
 	// https://github.com/cloudfoundry/bosh-dns-release/blob/master/jobs/bosh-dns/spec#L36-L38
-	defaultNameServer := "169.254.0.2"
-	if len(flag.Args()) > 4 && len(flag.Args()[4]) > 0 {
-		defaultNameServer = flag.Args()[4]
-	}
-	nameServers, err := readNameServers(resolvConfPath, defaultNameServer)
+	fs.StringVar(&o.defaultNameServer, "default-nameserver", "169.254.0.2", "nameserver to use when resolv.conf lists none")
+	fs.StringVar(&o.generatedIncludesPath, "generated-includes", "", "path to the rules generated from _redirects and _headers")
+}
+
+// addValidationFlag defines the flag of the commands that can render with
+// sample values.
+func (o *renderOptions) addValidationFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.validation, "validation", false, "use nginx.validation_env and placeholders for unset variables, to check the config while staging")
+}
+
+// newRenderer sets up the template functions of both rendering phases.
+func (o *renderOptions) newRenderer() (*renderer, error) {
+	resolv, err := readResolvConf(o.resolvConfPath, o.defaultNameServer)
 	if err != nil {
-		nameServers = []string{defaultNameServer}
 		log.Printf("Could not open %s file for reading. "+
-			"The default nameservers %s will be used. Error: %s", resolvConfPath, defaultNameServer, err)
+			"The default nameservers %s will be used. Error: %s", o.resolvConfPath, o.defaultNameServer, err)
 	}
 
-	body, err := os.ReadFile(filename)
+	bpYML, err := readBuildpackYML(o.buildpackYMLPath)
 	if err != nil {
-		log.Fatalf("Could not read config file: %s: %s", filename, err)
+		return nil, fmt.Errorf("Unable to read buildpath.yml path '%s'", o.buildpackYMLPath)
+	}
+	env := environment{plainText: bpYML.Nginx.PlaintextEnvVars, strict: bpYML.Nginx.Strict}
+	if o.validation {
+		env.samples = bpYML.Nginx.ValidationEnv
+		env.placeholders = findPlaceholders(o.confPath)
 	}
 
-	confBuf := bytes.Buffer{}
-	tempConfWriter := io.Writer(&confBuf)
+	plainTextFuncMap := textTemplate.FuncMap{
+		"port":             noArgIdentity("port"),
+		"module":           singleArgIdentity("module"),
+		"nameservers":      multiArgIdentity("nameservers"),
+		"search_domains":   noArgIdentity("search_domains"),
+		"ndots":            noArgIdentity("ndots"),
+		"fqdn":             singleArgIdentity("fqdn"),
+		"upstream_servers": multiArgIdentity("upstream_servers"),
+		"service":          multiArgIdentity("service"),
+		"service_by_tag":   multiArgIdentity("service_by_tag"),
+		"service_by_label": multiArgIdentity("service_by_label"),
+		"volume_mount":     multiArgIdentity("volume_mount"),
 
-	fileHandle, err := os.Create(filename)
-	if err != nil {
-		log.Fatalf("Could not open config file for writing: %s", err)
+		"generated_includes": noArgIdentity("generated_includes"),
 	}
-	defer fileHandle.Close()
-
-	plainTextEnvVars, err := getPlaintextEnvVars(*buildpackYMLPath)
-	if err != nil {
-		log.Fatalf("Unable to read buildpath.yml path '%s'", *buildpackYMLPath)
+	for _, name := range platformFuncNames {
+		plainTextFuncMap[name] = noArgIdentity(name)
 	}
-
-	plainTextFuncMap := textTemplate.FuncMap{
-		"env":         safeEnv(plainTextEnvVars),
-		"port":        noArgIdentity("port"),
-		"module":      singleArgIdentity("module"),
-		"nameservers": noArgIdentity("nameservers"),
+	for name, fn := range env.plainTextFuncs() {
+		plainTextFuncMap[name] = fn
 	}
 
-	htmlFuncMap := htmlTemplate.FuncMap{
-		"env": os.Getenv,
+	boundServices := newServices(os.Getenv("VCAP_SERVICES"))
+	appPlatform := newPlatform(os.Getenv("VCAP_APPLICATION"))
+
+	nginxFuncMap := textTemplate.FuncMap{
 		"port": func() string {
 			return os.Getenv("PORT")
 		},
-		"module": func(name string) string {
-			pathToModules := globalModulePath
-			foundLocally, err := libbuildpack.FileExists(filepath.Join(localModulePath, name+".so"))
+		"module": func(name string) (nginxSafe, error) {
+			pathToModules := o.globalModulePath
+			foundLocally, err := libbuildpack.FileExists(filepath.Join(o.localModulePath, name+".so"))
 			if err != nil {
-				log.Fatalf("Error looking for module in user provided modules directory: %s", err)
+				return "", fmt.Errorf("error looking for module in user provided modules directory: %s", err)
 			}
 			if foundLocally {
-				pathToModules = localModulePath
+				pathToModules = o.localModulePath
 			}
-			return fmt.Sprintf("load_module %s.so;", filepath.Join(pathToModules, name))
-		},
-		"nameservers": func() string {
-			return strings.Join(nameServers, " ")
+			return nginxSafe(fmt.Sprintf("load_module %s.so;", filepath.Join(pathToModules, name))), nil
 		},
-	}
-
-	configFiles := supply.GetIncludedConfs(string(body))
-	configFiles = append(configFiles, filename)
-
-	var str []byte
-	var configFileHandle *os.File
-	for i, confFile := range configFiles {
-		if i == len(configFiles)-1 {
-			str = body
-			configFileHandle = fileHandle
-		} else {
-			if !filepath.IsAbs(confFile) {
-				confFile = filepath.Join(filepath.Dir(filename), confFile)
-			}
-			str, err = os.ReadFile(confFile)
-			if err != nil {
-				log.Fatalf("Could not read config file: %s: %s", filename, err)
-			}
-			configFileHandle, err = os.Create(confFile)
-			if err != nil {
-				log.Fatalf("Could not open config file for writing: %s", err)
-			}
-			defer fileHandle.Close()
-		}
-
-		confBuf.Reset()
-		textT, err := textTemplate.New("tempconf").Option("missingkey=zero").Funcs(plainTextFuncMap).Parse(string(str))
-		if err != nil {
-			log.Fatalf("Could not parse config file: %s", err)
-		}
-		if err := textT.Execute(tempConfWriter, nil); err != nil {
-			log.Fatalf("Could not write temp config to buffer: %s", err)
-		}
-
-		htmlT, err := htmlTemplate.New("tempconf").Option("missingkey=zero").Funcs(htmlFuncMap).Parse(confBuf.String())
-		if err != nil {
-			log.Fatalf("Could not parse config file: %s", err)
-		}
-
-		if err := htmlT.Execute(configFileHandle, nil); err != nil {
-			log.Fatalf("Could not write config file: %s", err)
-		}
-	}
+		"nameservers":      resolv.Nameservers,
+		"search_domains":   resolv.SearchDomains,
+		"ndots":            resolv.Ndots,
+		"fqdn":             resolv.FQDN,
+		"upstream_servers": resolv.UpstreamServers,
+		"service":          boundServices.Service,
+		"service_by_tag":   boundServices.ServiceByTag,
+		"service_by_label": boundServices.ServiceByLabel,
+		"volume_mount":     boundServices.VolumeMount,
+
+		"generated_includes": o.generatedIncludes,
+
+		"app_uris":                  appPlatform.AppURIs,
+		"app_name":                  appPlatform.AppName,
+		"instance_index":            appPlatform.InstanceIndex,
+		"memory_limit_mb":           appPlatform.MemoryLimitMB,
+		"disk_limit_mb":             appPlatform.DiskLimitMB,
+		"cpu_count":                 appPlatform.CPUCount,
+		"auto_worker_processes":     appPlatform.AutoWorkerProcesses,
+		"auto_worker_connections":   appPlatform.AutoWorkerConnections,
+		"auto_worker_rlimit_nofile": appPlatform.AutoWorkerRlimitNofile,
+	}
+	for name, fn := range env.nginxFuncs() {
+		nginxFuncMap[name] = fn
+	}
+	for name, fn := range escaperFuncMap {
+		nginxFuncMap[name] = fn
+	}
+
+	return &renderer{
+		root:           filepath.Dir(o.confPath),
+		env:            env,
+		plainTextFuncs: plainTextFuncMap,
+		nginxFuncs:     nginxFuncMap,
+	}, nil
 }
 
-func readNameServers(resolvConfPath string, defaultNameServer string) ([]string, error) {
-	result := []string{defaultNameServer}
-
-	config, err := dns.ClientConfigFromFile(resolvConfPath)
-	if err != nil {
-		return []string{}, err
+// generatedIncludes includes the rules that supply generated from the
+// _redirects and _headers files of the app, if it has any.
+func (o *renderOptions) generatedIncludes() (nginxSafe, error) {
+	if o.generatedIncludesPath == "" {
+		return "", nil
 	}
-
-	if len(config.Servers) > 0 {
-		return config.Servers, nil
+	if exists, err := libbuildpack.FileExists(o.generatedIncludesPath); err != nil || !exists {
+		return "", err
 	}
-
-	return result, nil
+	return nginxSafe(fmt.Sprintf("include %s;", o.generatedIncludesPath)), nil
 }
 
 type BuildpackYML struct {
 	Nginx struct {
 		PlaintextEnvVars []string `yaml:"plaintext_env_vars"`
+		Strict           bool     `yaml:"strict"`
+		// ValidationEnv holds sample values for variables that are only set
+		// at runtime, used when validating while staging.
+		ValidationEnv map[string]string `yaml:"validation_env"`
 	} `yaml:"nginx"`
 }
 
-func getPlaintextEnvVars(bpYMLPath string) ([]string, error) {
+func readBuildpackYML(bpYMLPath string) (BuildpackYML, error) {
 	var bpYML BuildpackYML
 	exists, err := libbuildpack.FileExists(bpYMLPath)
 	if err != nil {
-		return []string{}, err
+		return bpYML, err
 	} else if bpYMLPath == "" || !exists {
-		return []string{}, nil
+		return bpYML, nil
 	}
 
 	bpYMLContents, err := os.ReadFile(bpYMLPath)
 	if err != nil {
-		return []string{}, err
+		return bpYML, err
 	}
 
 	if err = yaml.Unmarshal(bpYMLContents, &bpYML); err != nil {
-		return []string{}, err
+		return bpYML, err
 	}
 
-	return bpYML.Nginx.PlaintextEnvVars, nil
-}
-
-func safeEnv(keys []string) func(string) string {
-	return func(key string) string {
-		for _, safeKey := range keys {
-			if key == safeKey {
-				return os.Getenv(key)
-			}
-		}
-		return fmt.Sprintf(`{{env "%s"}}`, key)
-	}
+	return bpYML, nil
 }
 
 func singleArgIdentity(key string) func(string) string {
@@ -202,3 +220,20 @@ func noArgIdentity(key string) func() string {
 		return fmt.Sprintf(`{{%s}}`, key)
 	}
 }
+
+// multiArgIdentity passes an action through with its arguments as they were
+// written: strings quoted and numbers, such as the port of
+// upstream_servers, as they are.
+func multiArgIdentity(key string) func(...interface{}) string {
+	return func(vals ...interface{}) string {
+		action := key
+		for _, val := range vals {
+			if s, ok := val.(string); ok {
+				action += fmt.Sprintf(" %q", s)
+			} else {
+				action += fmt.Sprintf(" %v", val)
+			}
+		}
+		return fmt.Sprintf(`{{%s}}`, action)
+	}
+}
@@ -45,3 +45,13 @@ func runCli(tmpDir, body string, env []string, localModulePath, globalModulePath
 
 	return string(output), session
 }
+
+func runCliWithArgs(args []string, env []string, expectedExitcode int) *gexec.Session {
+	command := exec.Command(pathToCli, args...)
+	command.Env = env
+	session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+	Expect(err).ToNot(HaveOccurred())
+	Eventually(session).Should(gexec.Exit(expectedExitcode))
+
+	return session
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/site"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
@@ -48,6 +50,369 @@ nginx:
 			Expect(body).To(Equal(`The env var FOO is {"abcd":1234}`))
 		})
 
+		Describe("required and default values", func() {
+			It("templates required environment variables", func() {
+				body, _ := runCli(tmpDir, `proxy_pass {{required "UPSTREAM_URL"}};`, []string{"UPSTREAM_URL=http://backend"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`proxy_pass http://backend;`))
+			})
+
+			It("fails with the file and line when a required variable is missing", func() {
+				body, session := runCli(tmpDir, "server {\n  proxy_pass {{required \"UPSTREAM_URL\"}};\n}", nil, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`nginx.conf:2: required environment variable "UPSTREAM_URL" is not set`))
+				Expect(body).To(ContainSubstring(`{{required "UPSTREAM_URL"}}`))
+			})
+
+			It("names included files relative to nginx.conf", func() {
+				Expect(os.MkdirAll(filepath.Join(tmpDir, "conf.d"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "conf.d", "site.conf"), []byte("listen {{port}};\nproxy_pass {{required \"UPSTREAM_URL\"}};"), os.ModePerm)).To(Succeed())
+
+				_, session := runCli(tmpDir, `listen {{port}}; include conf.d/*.conf;`, []string{"PORT=8080"}, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`conf.d/site.conf:2: required environment variable "UPSTREAM_URL" is not set`))
+
+				contents, err := os.ReadFile(filepath.Join(tmpDir, "nginx.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`listen {{port}}; include conf.d/*.conf;`))
+			})
+
+			It("templates a fallback for unset environment variables", func() {
+				body, _ := runCli(tmpDir, `{{env_default "A" "fallback"}} {{env_default "B" "fallback"}}`, []string{"A=set"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`set fallback`))
+			})
+
+			It("names the line of template syntax errors", func() {
+				_, session := runCli(tmpDir, "events {}\nlisten {{port;\n", nil, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`nginx.conf:2: `))
+			})
+
+			Context("in strict mode", func() {
+				var bpYMLPath string
+
+				BeforeEach(func() {
+					bpYMLPath = filepath.Join(tmpDir, "buildpack.yml")
+					Expect(os.WriteFile(bpYMLPath, []byte("nginx:\n  strict: true\n  plaintext_env_vars: [PLAIN]\n"), os.ModePerm)).To(Succeed())
+				})
+
+				It("fails on unset environment variables", func() {
+					_, session := runCli(tmpDir, `proxy_pass {{env "UPSTREAM_URL"}};`, nil, "", "", "", "", bpYMLPath, 1)
+					Expect(session.Err).To(gbytes.Say(`nginx.conf:1: environment variable "UPSTREAM_URL" is not set`))
+				})
+
+				It("fails on unset plaintext environment variables", func() {
+					_, session := runCli(tmpDir, `{{env "PLAIN"}}`, nil, "", "", "", "", bpYMLPath, 1)
+					Expect(session.Err).To(gbytes.Say(`nginx.conf:1: environment variable "PLAIN" is not set`))
+				})
+
+				It("allows environment variables that are set to be empty", func() {
+					body, _ := runCli(tmpDir, `[{{env "EMPTY"}}] {{env_default "UNSET" "x"}}`, []string{"EMPTY="}, "", "", "", "", bpYMLPath, 0)
+					Expect(body).To(Equal(`[] x`))
+				})
+			})
+		})
+
+		Describe("escaping values for nginx", func() {
+			It("does not HTML escape values", func() {
+				body, _ := runCli(tmpDir, `proxy_pass {{env "URL"}};`, []string{"URL=http://backend/?a=1&b=<2>"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`proxy_pass http://backend/?a=1&b=<2>;`))
+			})
+
+			It("escapes values inside quoted strings", func() {
+				body, _ := runCli(tmpDir, `add_header X-A "{{env "VAL"}}"; add_header X-B '{{env "VAL"}}';`, []string{`VAL=it's "quoted" \ here`}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`add_header X-A "it's \"quoted\" \\ here"; add_header X-B 'it\'s "quoted" \\ here';`))
+			})
+
+			It("quotes values with spaces outside of quoted strings", func() {
+				body, _ := runCli(tmpDir, `add_header X-A {{env "VAL"}};`, []string{`VAL=a "b"`}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`add_header X-A "a \"b\"";`))
+			})
+
+			It("escapes values in the middle of an argument without quoting them", func() {
+				body, _ := runCli(tmpDir, `proxy_pass http://{{env "HOST"}}/path;`, []string{"HOST=a\tb\\c\"d"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`proxy_pass http://a\tb\\c"d/path;`))
+			})
+
+			It("refuses values with spaces in the middle of an argument", func() {
+				_, session := runCli(tmpDir, `proxy_pass http://{{env "HOST"}}/path;`, []string{"HOST=a b"}, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`refusing to insert a value containing ' ' into part of an argument`))
+			})
+
+			It("refuses values with spaces at the start of an argument that the template continues", func() {
+				_, session := runCli(tmpDir, `root {{env "DIR"}}/path;`, []string{"DIR=a b"}, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`refusing to insert a value containing ' ' into part of an argument`))
+			})
+
+			It("escapes a quote at the start of an argument that the template continues", func() {
+				body, _ := runCli(tmpDir, `root {{env "DIR"}}/path;`, []string{`DIR="a`}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`root \"a/path;`))
+			})
+
+			It("quotes empty values so that the argument is kept", func() {
+				body, _ := runCli(tmpDir, `add_header X-A {{env "VAL"}};`, []string{"VAL="}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`add_header X-A "";`))
+			})
+
+			It("refuses values with $, which nginx would expand", func() {
+				for _, conf := range []string{`set $x {{env "VAL"}};`, `set $x "{{env "VAL"}}";`, `set $x a{{env "VAL"}};`} {
+					_, session := runCli(tmpDir, conf, []string{"VAL=p$ss"}, "", "", "", "", "", 1)
+					Expect(session.Err).To(gbytes.Say(`refusing to insert a value containing '\$', which nginx would expand as a variable`))
+				}
+			})
+
+			It("leaves {{ in the values of a config generated from nginx.site as it is", func() {
+				conf := site.Generate(site.Config{Headers: map[string]string{"X-Template": `{{port}} {{{ }}`}})
+				body, _ := runCli(tmpDir, conf, []string{"PORT=8080"}, "", "", "", "", "", 0)
+				Expect(body).To(ContainSubstring(`add_header X-Template "{{port}} {{{ }}" always;`))
+			})
+
+			It("allows ; and } inside quoted strings", func() {
+				body, _ := runCli(tmpDir, `return 200 "{{env "VAL"}}";`, []string{"VAL=a; } b"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`return 200 "a; } b";`))
+			})
+
+			It("keeps values in comments on one line", func() {
+				body, _ := runCli(tmpDir, "# {{env \"VAL\"}}\n", []string{"VAL=a\nreturn 500;"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal("# a return 500;\n"))
+			})
+
+			It("refuses values that would end the directive outside of quoted strings", func() {
+				_, session := runCli(tmpDir, `add_header X-A {{env "VAL"}};`, []string{"VAL=a; return 200"}, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`refusing to insert a value containing ';' outside of quotes`))
+			})
+
+			It("refuses values that would close the block outside of quoted strings", func() {
+				_, session := runCli(tmpDir, `server { listen {{env "VAL"}}; }`, []string{"VAL=8080; } server {"}, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`refusing to insert a value containing`))
+			})
+		})
+
+		Describe("templating service bindings", func() {
+			const vcapServices = `VCAP_SERVICES={
+  "p.redis": [{
+    "name": "my-redis",
+    "label": "p.redis",
+    "tags": ["redis", "cache"],
+    "credentials": {"host": "10.0.0.5", "port": 6379, "password": "p@ss w;rd"}
+  }],
+  "user-provided": [
+    {"name": "backend", "label": "user-provided", "tags": [], "credentials": {"url": "http://backend.internal:8080"}},
+    {"name": "other", "label": "user-provided", "tags": ["cache"], "credentials": {}}
+  ],
+  "nfs": [{
+    "name": "shared-files",
+    "label": "nfs",
+    "tags": ["nfs"],
+    "credentials": {},
+    "volume_mounts": [{"container_dir": "/var/vcap/data/shared", "mode": "rw"}]
+  }]
+}`
+
+			It("looks up credentials by binding name", func() {
+				body, _ := runCli(tmpDir, `server {{service "my-redis" "credentials.host"}}:{{service "my-redis" "credentials.port"}};`, []string{vcapServices}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`server 10.0.0.5:6379;`))
+			})
+
+			It("escapes credentials like other values", func() {
+				body, _ := runCli(tmpDir, `set $password "{{service "my-redis" "credentials.password"}}";`, []string{vcapServices}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`set $password "p@ss w;rd";`))
+			})
+
+			It("looks up credentials by tag", func() {
+				body, _ := runCli(tmpDir, `{{service_by_tag "redis" "credentials.host"}}`, []string{vcapServices}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`10.0.0.5`))
+			})
+
+			It("looks up credentials by label", func() {
+				body, _ := runCli(tmpDir, `{{service_by_label "p.redis" "name"}}`, []string{vcapServices}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`my-redis`))
+			})
+
+			It("looks up volume mount paths", func() {
+				body, _ := runCli(tmpDir, `root {{volume_mount "shared-files"}};`, []string{vcapServices}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`root /var/vcap/data/shared;`))
+			})
+
+			DescribeTable("failing with a clear error",
+				func(template, message string) {
+					_, session := runCli(tmpDir, template, []string{vcapServices}, "", "", "", "", "", 1)
+					Expect(session.Err).To(gbytes.Say(regexp.QuoteMeta(message)))
+				},
+				Entry("missing binding", `{{service "missing" "credentials.host"}}`, `no service bound with name "missing"`),
+				Entry("missing key", `{{service "backend" "credentials.host"}}`, `service bound with name "backend" has no "credentials.host"`),
+				Entry("ambiguous tag", `{{service_by_tag "cache" "name"}}`, `2 services bound with tag "cache", expected one`),
+				Entry("ambiguous label", `{{service_by_label "user-provided" "name"}}`, `2 services bound with label "user-provided", expected one`),
+				Entry("no volume mounts", `{{volume_mount "backend"}}`, `service bound with name "backend" has no volume mounts`),
+			)
+
+			It("fails when VCAP_SERVICES is not set", func() {
+				_, session := runCli(tmpDir, `{{service "my-redis" "name"}}`, nil, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`VCAP_SERVICES is not set`))
+			})
+		})
+
+		Describe("templating platform facts", func() {
+			const vcapApplication = `VCAP_APPLICATION={
+  "application_name": "my-app",
+  "application_uris": ["my-app.example.com", "www.example.com/api", "my-app.example.com/v2"],
+  "instance_index": 3,
+  "limits": {"mem": 2048, "disk": 1024}
+}`
+
+			It("templates the host names of the app routes", func() {
+				body, _ := runCli(tmpDir, `server_name {{app_uris}};`, []string{vcapApplication}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`server_name my-app.example.com www.example.com;`))
+			})
+
+			It("templates the app name and instance index", func() {
+				body, _ := runCli(tmpDir, `{{app_name}}-{{instance_index}}`, []string{vcapApplication}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`my-app-3`))
+			})
+
+			It("prefers CF_INSTANCE_INDEX for the instance index", func() {
+				body, _ := runCli(tmpDir, `{{instance_index}}`, []string{vcapApplication, "CF_INSTANCE_INDEX=5"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`5`))
+			})
+
+			It("templates the limits from VCAP_APPLICATION", func() {
+				body, _ := runCli(tmpDir, `{{memory_limit_mb}} {{disk_limit_mb}}`, []string{vcapApplication}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`2048 1024`))
+			})
+
+			It("prefers MEMORY_LIMIT for the memory limit", func() {
+				body, _ := runCli(tmpDir, `{{memory_limit_mb}}`, []string{vcapApplication, "MEMORY_LIMIT=1G"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`1024`))
+			})
+
+			It("templates the CPU count", func() {
+				body, _ := runCli(tmpDir, `{{cpu_count}}`, nil, "", "", "", "", "", 0)
+				Expect(body).To(MatchRegexp(`^[1-9][0-9]*$`))
+			})
+
+			It("limits worker processes by the memory share of the container", func() {
+				body, _ := runCli(tmpDir, `worker_processes {{auto_worker_processes}};`, []string{"MEMORY_LIMIT=512m"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`worker_processes 1;`))
+			})
+
+			It("limits worker connections by memory", func() {
+				body, _ := runCli(tmpDir, `worker_connections {{auto_worker_connections}};`, []string{"MEMORY_LIMIT=16m"}, "", "", "", "", "", 0)
+				Expect(body).To(Equal(`worker_connections 512;`))
+			})
+
+			It("templates a worker_rlimit_nofile value", func() {
+				body, _ := runCli(tmpDir, `{{auto_worker_rlimit_nofile}}`, nil, "", "", "", "", "", 0)
+				Expect(body).To(MatchRegexp(`^[1-9][0-9]*$`))
+			})
+
+			It("fails when VCAP_APPLICATION is not set", func() {
+				_, session := runCli(tmpDir, `server_name {{app_uris}};`, nil, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`VCAP_APPLICATION is not set`))
+			})
+
+			It("fails to size workers on an invalid MEMORY_LIMIT", func() {
+				for _, conf := range []string{`{{auto_worker_processes}}`, `{{auto_worker_connections}}`} {
+					_, session := runCli(tmpDir, conf, []string{"MEMORY_LIMIT=1X"}, "", "", "", "", "", 1)
+					Expect(session.Err).To(gbytes.Say(`invalid MEMORY_LIMIT "1X"`))
+				}
+			})
+
+			It("fails on an invalid MEMORY_LIMIT", func() {
+				_, session := runCli(tmpDir, `{{memory_limit_mb}}`, []string{"MEMORY_LIMIT=lots"}, "", "", "", "", "", 1)
+				Expect(session.Err).To(gbytes.Say(`invalid MEMORY_LIMIT "lots"`))
+			})
+		})
+
+		Describe("rendering into an output directory", func() {
+			var outputDir string
+
+			BeforeEach(func() {
+				outputDir = filepath.Join(tmpDir, "rendered")
+				Expect(os.MkdirAll(filepath.Join(tmpDir, "conf.d"), os.ModePerm)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(tmpDir, "public"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "nginx.conf"), []byte(`include mime.types; include conf.d/*.conf;`), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "mime.types"), []byte(`types { text/html html; }`), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "conf.d", "site.conf"), []byte(`listen {{port}};`), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "conf.d", "README"), []byte(`notes`), os.ModePerm)).To(Succeed())
+			})
+
+			It("leaves the templates untouched", func() {
+				runCliWithArgs([]string{"-output-dir", outputDir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 0)
+
+				contents, err := os.ReadFile(filepath.Join(tmpDir, "conf.d", "site.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`listen {{port}};`))
+			})
+
+			It("mirrors the layout of the config directory", func() {
+				runCliWithArgs([]string{"-output-dir", outputDir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 0)
+
+				contents, err := os.ReadFile(filepath.Join(outputDir, "conf.d", "site.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`listen 8080;`))
+
+				contents, err = os.ReadFile(filepath.Join(outputDir, "nginx.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`include mime.types; include conf.d/*.conf;`))
+
+				contents, err = os.ReadFile(filepath.Join(outputDir, "mime.types"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`types { text/html html; }`))
+
+				for _, path := range []string{"public", filepath.Join("conf.d", "README")} {
+					target, err := os.Readlink(filepath.Join(outputDir, path))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(target).To(Equal(filepath.Join(tmpDir, path)))
+				}
+			})
+
+			It("replaces a previous render", func() {
+				Expect(os.MkdirAll(outputDir, os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(outputDir, "stale.conf"), []byte(``), os.ModePerm)).To(Succeed())
+
+				runCliWithArgs([]string{"-output-dir", outputDir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 0)
+
+				Expect(filepath.Join(outputDir, "stale.conf")).NotTo(BeAnExistingFile())
+				Expect(filepath.Glob(filepath.Join(tmpDir, ".rendered.*"))).To(BeEmpty())
+			})
+
+			It("refuses to clear the config directory or a directory holding it", func() {
+				for _, dir := range []string{tmpDir, filepath.Dir(tmpDir)} {
+					session := runCliWithArgs([]string{"-output-dir", dir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 1)
+					Expect(session.Err).To(gbytes.Say(`Refusing to clear output directory`))
+				}
+				Expect(filepath.Join(tmpDir, "nginx.conf")).To(BeAnExistingFile())
+			})
+
+			It("refuses absolute includes of templates, which nginx would read unrendered", func() {
+				Expect(os.WriteFile(filepath.Join(tmpDir, "nginx.conf"), []byte("include mime.types;\ninclude "+filepath.Join(tmpDir, "conf.d", "*.conf")+";"), os.ModePerm)).To(Succeed())
+
+				session := runCliWithArgs([]string{"-output-dir", outputDir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 1)
+				Expect(session.Err).To(gbytes.Say(`nginx.conf:2 includes .*/conf.d/\*.conf by its absolute path, so nginx would read the template instead of the rendered file; include it as conf.d/site.conf instead`))
+				Expect(outputDir).NotTo(BeADirectory())
+			})
+
+			It("allows absolute includes of files that need no rendering", func() {
+				Expect(os.WriteFile(filepath.Join(tmpDir, "nginx.conf"), []byte("include "+filepath.Join(tmpDir, "mime.types")+";\ninclude conf.d/*.conf;"), os.ModePerm)).To(Succeed())
+
+				runCliWithArgs([]string{"-output-dir", outputDir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 0)
+			})
+
+			It("does not write anything when rendering fails", func() {
+				Expect(os.WriteFile(filepath.Join(tmpDir, "conf.d", "site.conf"), []byte(`listen {{required "NOPE"}};`), os.ModePerm)).To(Succeed())
+
+				session := runCliWithArgs([]string{"-output-dir", outputDir, filepath.Join(tmpDir, "nginx.conf"), "", ""}, nil, 1)
+				Expect(session.Err).To(gbytes.Say(`conf.d/site.conf:1: required environment variable "NOPE" is not set`))
+				Expect(outputDir).NotTo(BeADirectory())
+			})
+
+			It("prints the rendered files to stdout", func() {
+				session := runCliWithArgs([]string{"-print", filepath.Join(tmpDir, "nginx.conf"), "", ""}, []string{"PORT=8080"}, 0)
+
+				Expect(string(session.Out.Contents())).To(Equal("# --- nginx.conf ---\ninclude mime.types; include conf.d/*.conf;\n# --- mime.types ---\ntypes { text/html html; }\n# --- conf.d/site.conf ---\nlisten 8080;\n"))
+
+				contents, err := os.ReadFile(filepath.Join(tmpDir, "conf.d", "site.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`listen {{port}};`))
+			})
+		})
+
 		Describe("templating conf with include files", func() {
 			It("parses include file", func() {
 				const nginxConfStr = `
@@ -70,6 +435,19 @@ nginx:
 	}
 `))
 			})
+
+			It("parses nested and glob include files", func() {
+				Expect(os.MkdirAll(filepath.Join(tmpDir, "conf.d"), os.ModePerm)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(tmpDir, "snippets"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "conf.d", "site.conf"), []byte(`server { include "snippets/listen"; }`), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(tmpDir, "snippets", "listen"), []byte(`listen {{port}};`), os.ModePerm)).To(Succeed())
+
+				runCli(tmpDir, `http { include conf.d/*.conf; }`, []string{"PORT=8080"}, "", "", "", "", "", 0)
+
+				contents, err := os.ReadFile(filepath.Join(tmpDir, "snippets", "listen"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(`listen 8080;`))
+			})
 		})
 
 		Context("templating a load_module directive using the 'module' func", func() {
@@ -135,6 +513,46 @@ nginx:
 				body, _ := runCli(tmpDir, "Hi the nameservers are {{nameservers}}.", nil, "", "", "not-existing-file.conf", defaultNameServer, "", 0)
 				Expect(body).To(Equal("Hi the nameservers are " + defaultNameServer + "."))
 			})
+
+			It("puts IPv6 nameservers in brackets", func() {
+				var resolvConfPath = filepath.Join(tmpDir, "resolv-ipv6.conf")
+				Expect(os.WriteFile(resolvConfPath, []byte("nameserver "+nameserver1+"\nnameserver fd00:ec2::253\n"), 0644)).To(Succeed())
+				body, _ := runCli(tmpDir, "resolver {{nameservers}};", nil, "", "", resolvConfPath, defaultNameServer, "", 0)
+				Expect(body).To(Equal("resolver " + nameserver1 + " [fd00:ec2::253];"))
+			})
+
+			It("appends resolver options", func() {
+				var resolvConfPath = filepath.Join(tmpDir, "resolv-simple.conf")
+				Expect(os.WriteFile(resolvConfPath, []byte("nameserver "+nameserver1), 0644)).To(Succeed())
+				body, _ := runCli(tmpDir, `resolver {{nameservers "valid=30s" "ipv6=off"}};`, nil, "", "", resolvConfPath, defaultNameServer, "", 0)
+				Expect(body).To(Equal("resolver " + nameserver1 + " valid=30s ipv6=off;"))
+			})
+
+			It("rejects unknown resolver options", func() {
+				var resolvConfPath = filepath.Join(tmpDir, "resolv-simple.conf")
+				Expect(os.WriteFile(resolvConfPath, []byte("nameserver "+nameserver1), 0644)).To(Succeed())
+				_, session := runCli(tmpDir, `resolver {{nameservers "ipv6=maybe"}};`, nil, "", "", resolvConfPath, defaultNameServer, "", 1)
+				Expect(session.Err).To(gbytes.Say(`nginx.conf:1: invalid resolver option "ipv6=maybe", ipv6 must be on or off`))
+			})
+
+			Context("with search domains", func() {
+				var resolvConfPath string
+
+				BeforeEach(func() {
+					resolvConfPath = filepath.Join(tmpDir, "resolv-search.conf")
+					Expect(os.WriteFile(resolvConfPath, []byte("nameserver "+nameserver1+"\nsearch apps.internal service.cf.internal\noptions ndots:2\n"), 0644)).To(Succeed())
+				})
+
+				It("templates the search domains and ndots", func() {
+					body, _ := runCli(tmpDir, "# {{search_domains}} ndots={{ndots}}", nil, "", "", resolvConfPath, defaultNameServer, "", 0)
+					Expect(body).To(Equal("# apps.internal service.cf.internal ndots=2"))
+				})
+
+				It("qualifies short host names with the first search domain", func() {
+					body, _ := runCli(tmpDir, `{{fqdn "backend"}} {{fqdn "backend.apps"}} {{fqdn "a.b.c"}} {{fqdn "backend."}}`, nil, "", "", resolvConfPath, defaultNameServer, "", 0)
+					Expect(body).To(Equal("backend.apps.internal backend.apps.apps.internal a.b.c backend."))
+				})
+			})
 		})
 
 		Context("Failure cases", func() {
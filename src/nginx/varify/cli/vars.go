@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	textTemplate "text/template"
+	"text/template/parse"
+
+	"github.com/cloudfoundry/nginx-buildpack/src/nginx/supply"
+)
+
+// variableRef is a reference to an environment variable in a template.
+type variableRef struct {
+	Name string
+	Func string
+	// Staging is where the check while staging takes the value from:
+	// validation_env, env, default, placeholder or unset. Nothing is baked
+	// in while staging, so every variable is resolved again at runtime, when
+	// the launcher renders the config at app start.
+	Staging  string
+	Location string
+}
+
+// findVariables returns the environment variables referenced by the config
+// file at confPath and the files it includes, in source order.
+func findVariables(r *renderer, confPath string) ([]variableRef, error) {
+	includes, err := supply.ResolveIncludes(confPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %s: %s", confPath, err)
+	}
+
+	refs := []variableRef{}
+	for _, confFile := range append([]string{confPath}, includes...) {
+		source, err := os.ReadFile(confFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read config file: %s: %s", confFile, err)
+		}
+
+		name := r.name(confFile)
+		t, err := textTemplate.New(name).Funcs(r.plainTextFuncs).Parse(string(source))
+		if err != nil {
+			return nil, newTemplateError(name, err)
+		}
+
+		for _, tmpl := range t.Templates() {
+			if tmpl.Tree == nil {
+				continue
+			}
+			walkCommands(tmpl.Tree.Root, func(cmd *parse.CommandNode) {
+				if ref, ok := r.variableRef(tmpl.Tree, cmd); ok {
+					refs = append(refs, ref)
+				}
+			})
+		}
+	}
+
+	return refs, nil
+}
+
+func (r *renderer) variableRef(tree *parse.Tree, cmd *parse.CommandNode) (variableRef, bool) {
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return variableRef{}, false
+	}
+	location, _ := tree.ErrorContext(cmd)
+	location = strings.Join(strings.SplitN(location, ":", 3)[:2], ":")
+
+	switch ident.Ident {
+	case "port":
+		return variableRef{Name: "PORT", Func: ident.Ident, Staging: "env", Location: location}, true
+	case "env", "required", "env_default":
+		if len(cmd.Args) < 2 {
+			return variableRef{}, false
+		}
+		key, ok := cmd.Args[1].(*parse.StringNode)
+		if !ok {
+			return variableRef{}, false
+		}
+		return variableRef{Name: key.Text, Func: ident.Ident, Staging: r.env.stagingSource(ident.Ident, key.Text), Location: location}, true
+	}
+
+	return variableRef{}, false
+}
+
+// walkCommands calls fn for every command in the pipelines under node.
+func walkCommands(node parse.Node, fn func(*parse.CommandNode)) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			walkCommands(n, fn)
+		}
+	case *parse.ActionNode:
+		walkCommands(node.Pipe, fn)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			fn(cmd)
+			for _, arg := range cmd.Args {
+				walkCommands(arg, fn)
+			}
+		}
+	case *parse.IfNode:
+		walkBranch(&node.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&node.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&node.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkCommands(node.Pipe, fn)
+	}
+}
+
+func walkBranch(branch *parse.BranchNode, fn func(*parse.CommandNode)) {
+	walkCommands(branch.Pipe, fn)
+	walkCommands(branch.List, fn)
+	walkCommands(branch.ElseList, fn)
+}